@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+// tokenLifetime is how long an api.swgoh.help access token is reused.
+// swgohhelp.Client.SignIn does not return the expiration, so tokens are
+// refreshed a bit before the one hour lifetime of the API tokens.
+const tokenLifetime = 50 * time.Minute
+
+// apiStatusRe extracts the HTTP status code from the swgohhelp errors.
+var apiStatusRe = regexp.MustCompile("unexpected status code calling .*: ([0-9]{3}) ")
+
+// APIClient is the api.swgoh.help client shared by all commands. It signs in
+// once, reuses the access token until it expires or is refused, and retries
+// the calls that fail with transient errors.
+type APIClient struct {
+	username, password string
+
+	// Retries is the number of times a failed call is retried,
+	// waiting Backoff before the first retry and doubling it after each one.
+	Retries int
+	Backoff time.Duration
+
+	mu      sync.Mutex
+	client  *swgohhelp.Client
+	expires time.Time
+
+	newClient func() *swgohhelp.Client
+	signIn    func(c *swgohhelp.Client, username, password string) error
+	now       func() time.Time
+}
+
+// NewAPIClient creates an APIClient that signs in with username and password.
+func NewAPIClient(username, password string) *APIClient {
+	return &APIClient{
+		username: username,
+		password: password,
+		Retries:  3,
+		Backoff:  500 * time.Millisecond,
+		newClient: func() *swgohhelp.Client {
+			return swgohhelp.New(context.Background())
+		},
+		signIn: func(c *swgohhelp.Client, username, password string) error {
+			_, err := c.SignIn(username, password)
+			return err
+		},
+		now: time.Now,
+	}
+}
+
+// authenticated returns a signed in client, signing in again if the token expired.
+// A new swgohhelp.Client is created on each sign in, so calls still running with
+// the previous client are not affected.
+func (a *APIClient) authenticated() (*swgohhelp.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client != nil && a.now().Before(a.expires) {
+		return a.client, nil
+	}
+	c := a.newClient()
+	if err := a.signIn(c, a.username, a.password); err != nil {
+		return nil, fmt.Errorf("apiclient: unable to sign in: %v", err)
+	}
+	a.client, a.expires = c, a.now().Add(tokenLifetime)
+	logger.Printf("Signed in to api.swgoh.help, token valid until %v", a.expires.Format(time.Stamp))
+	return c, nil
+}
+
+// invalidate discards the client token, if c is still the current client.
+func (a *APIClient) invalidate(c *swgohhelp.Client) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client == c {
+		a.client = nil
+	}
+}
+
+// Do calls fn with an authenticated client, retrying on transient errors and
+// signing in again when the token is refused. The client requests the game
+// texts in the language of ctx, see withLanguage.
+func (a *APIClient) Do(ctx context.Context, fn func(api *swgohhelp.Client) error) error {
+	backoff := a.Backoff
+	for attempt := 0; ; attempt++ {
+		var api *swgohhelp.Client
+		start := time.Now()
+		err := runWithContext(ctx, func() (err error) {
+			if api, err = a.authenticated(); err != nil {
+				return err
+			}
+			return fn(api.WithLanguage(contextLanguage(ctx)))
+		})
+		upstreamDuration.Since(start, "swgohhelp", upstreamOutcome(err))
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		status := apiStatus(err)
+		if status == 401 {
+			a.invalidate(api)
+		} else if !isTransient(err, status) {
+			return err
+		}
+		if attempt >= a.Retries {
+			return err
+		}
+		contextLogger(ctx, logger).Warnf("API call failed (attempt %d), retrying in %v: %v", attempt+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// Player fetches the player profile.
+func (a *APIClient) Player(ctx context.Context, allyCode string) (player *swgohhelp.Player, err error) {
+	err = a.Do(ctx, func(api *swgohhelp.Client) (err error) {
+		player, err = fetchPlayer(api, allyCode)
+		return err
+	})
+	return player, err
+}
+
+// apiStatus returns the HTTP status code from a swgohhelp error, or zero.
+func apiStatus(err error) int {
+	m := apiStatusRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	status, _ := strconv.Atoi(m[1])
+	return status
+}
+
+// isTransient returns true if the error may go away by trying again:
+// network errors, rate limits and server errors.
+func isTransient(err error, status int) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return status == 429 || status >= 500
+}
+
+// fetchPlayer fetches the player profile using the api client.
+func fetchPlayer(api *swgohhelp.Client, allyCode string) (*swgohhelp.Player, error) {
+	players, err := api.Players(allyCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(players) == 0 {
+		return nil, fmt.Errorf("player %s not found", allyCode)
+	}
+	return &players[0], nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+func newTestAPIClient(signIns *int) *APIClient {
+	a := NewAPIClient("user", "pass")
+	a.Backoff = time.Millisecond
+	a.newClient = func() *swgohhelp.Client { return &swgohhelp.Client{} }
+	a.signIn = func(c *swgohhelp.Client, username, password string) error {
+		*signIns++
+		return nil
+	}
+	return a
+}
+
+func TestAPIClient(t *testing.T) {
+	var signIns int
+	now := time.Now()
+	a := newTestAPIClient(&signIns)
+	a.now = func() time.Time { return now }
+
+	ok := func(api *swgohhelp.Client) error { return nil }
+	for i := 0; i < 3; i++ {
+		if err := a.Do(context.Background(), ok); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if signIns != 1 {
+		t.Errorf("Unexpected sign ins reusing the token: %d, expected 1", signIns)
+	}
+
+	now = now.Add(tokenLifetime)
+	a.Do(context.Background(), ok)
+	if signIns != 2 {
+		t.Errorf("Unexpected sign ins after token expiration: %d, expected 2", signIns)
+	}
+
+	calls := 0
+	unauthorized := func(api *swgohhelp.Client) error {
+		if calls++; calls == 1 {
+			return fmt.Errorf("swgohhelp: unexpected status code calling /swgoh/players: 401 Unauthorized")
+		}
+		return nil
+	}
+	if err := a.Do(context.Background(), unauthorized); err != nil {
+		t.Errorf("Unexpected error after 401: %v", err)
+	}
+	if signIns != 3 || calls != 2 {
+		t.Errorf("Unexpected sign ins/calls after 401: %d/%d, expected 3/2", signIns, calls)
+	}
+
+	calls = 0
+	unavailable := func(api *swgohhelp.Client) error {
+		calls++
+		return fmt.Errorf("swgohhelp: unexpected status code calling /swgoh/players: 503 Service Unavailable")
+	}
+	if err := a.Do(context.Background(), unavailable); err == nil || apiStatus(err) != 503 {
+		t.Errorf("Unexpected error after retries: %v", err)
+	}
+	if calls != a.Retries+1 {
+		t.Errorf("Unexpected calls with transient errors: %d, expected %d", calls, a.Retries+1)
+	}
+
+	calls = 0
+	notFound := func(api *swgohhelp.Client) error {
+		calls++
+		return fmt.Errorf("player 123456789 not found")
+	}
+	if err := a.Do(context.Background(), notFound); err == nil || calls != 1 {
+		t.Errorf("Unexpected retry of permanent error: %v (%d calls)", err, calls)
+	}
+}
+
+func TestAPIClientLanguage(t *testing.T) {
+	var signIns int
+	a := newTestAPIClient(&signIns)
+	// The test client has no language, so the API default is used
+	for ctx, expected := range map[context.Context]string{
+		context.Background():                         "",
+		withLanguage(context.Background(), ""):       "",
+		withLanguage(context.Background(), "por_br"): "por_br",
+	} {
+		a.Do(ctx, func(api *swgohhelp.Client) error {
+			if api.Language() != expected {
+				t.Errorf("Unexpected client language: %v, expected %v", api.Language(), expected)
+			}
+			return nil
+		})
+	}
+}
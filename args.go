@@ -37,8 +37,15 @@ var (
 	mentionRe    = regexp.MustCompile("\\<@!?-?[0-9]+\\>")
 )
 
-// ParseArgs parses the user command into a structured Args object.
+// ParseArgs parses the user command into a structured Args object,
+// using the default command prefix.
 func ParseArgs(line string) *Args {
+	return ParseCommand(line, conf().Prefix)
+}
+
+// ParseCommand parses the user command into a structured Args object,
+// removing the provided prefix from the command name.
+func ParseCommand(line, prefix string) *Args {
 	// Extract metadata first
 	profile := profileArgRe.FindAllString(line, -1)
 	flags := flagsRe.FindAllString(line, -1)
@@ -60,7 +67,7 @@ func ParseArgs(line string) *Args {
 	if len(fields) == 0 {
 		return &opts
 	}
-	opts.Command = strings.ToLower(fields[0][len(*cmdPrefix):])
+	opts.Command = strings.ToLower(strings.TrimPrefix(fields[0], prefix))
 	opts.Name = strings.Join(fields[1:], " ")
 	return &opts
 }
@@ -76,3 +83,15 @@ func (o *Args) ContainsFlag(flags ...string) bool {
 	}
 	return false
 }
+
+// Account returns the account label selected in the command, either as
+// the profile, like [alt1], or as a flag, like +alt1. Only the provided
+// labels are considered, so regular flags are not mistaken by accounts.
+func (o *Args) Account(labels []string) string {
+	for _, label := range labels {
+		if strings.ToLower(o.Profile) == label || o.ContainsFlag("+"+label) {
+			return label
+		}
+	}
+	return ""
+}
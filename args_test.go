@@ -53,3 +53,32 @@ func TestParseArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestArgsAccount(t *testing.T) {
+	labels := []string{"main", "alt1"}
+	testCases := []struct {
+		in      string
+		account string
+	}{
+		{in: "/stats tfp", account: ""},
+		{in: "/stats tfp +alt1", account: "alt1"},
+		{in: "/stats tfp [alt1]", account: "alt1"},
+		{in: "/stats tfp [Main]", account: "main"},
+		{in: "/stats tfp +alt2", account: ""},
+		{in: "/stats tfp [ronoaldo] +more", account: ""},
+	}
+	for i, tc := range testCases {
+		if account := ParseArgs(tc.in).Account(labels); account != tc.account {
+			t.Errorf("Test case #%d: `%s` -> unexpected account '%v', expected '%v'", i, tc.in, account, tc.account)
+		}
+	}
+}
+
+func TestParseCommandPrefix(t *testing.T) {
+	for _, prefix := range []string{"/", "!", "ap5r."} {
+		o := ParseCommand(prefix+"stats tfp +more", prefix)
+		if o.Command != "stats" || o.Name != "tfp" || !o.ContainsFlag("+more") {
+			t.Errorf("Unexpected args for prefix '%s': %#v", prefix, o)
+		}
+	}
+}
@@ -1,41 +1,375 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/ronoaldo/swgoh/swgohgg"
 )
 
 // Cache holds guild-based cache information.
+// Profile links are kept in memory and saved to the bot Store,
+// so they survive restarts without scanning the #swgoh-gg channel again.
+// Each user may have several linked accounts, see ProfileLink.
 type Cache struct {
-	guildID         string
-	profiles        map[string]string
-	allyCodes       map[string]string
-	profilesMutex   sync.Mutex
-	allyCodesMutext sync.Mutex
-	logger          *Logger
+	guildID   string
+	guildName string
+	links     map[string][]*ProfileLink
+	linksMu   sync.Mutex
+	settings  GuildSettings
+	settingMu sync.Mutex
+	store     *Store
+	logger    *Logger
 }
 
-// NewCache creates a new cache for the given guild ID.
-func NewCache(guildID, guildName string) *Cache {
-	return &Cache{
+// defaultAccountLabel is the label of the first account linked by an user.
+const defaultAccountLabel = "main"
+
+// accountLabelRe validates account labels, so they can be used as flags.
+var accountLabelRe = regexp.MustCompile("^[a-z][a-z0-9]{0,15}$")
+
+// NewCache creates a new cache for the given guild ID, loading
+// any previously saved profile links from the store.
+func NewCache(store *Store, guildID, guildName string) *Cache {
+	c := &Cache{
 		guildID:   guildID,
-		profiles:  make(map[string]string),
-		allyCodes: make(map[string]string),
-		logger:    &Logger{Guild: guildName},
+		guildName: guildName,
+		store:     store,
+		logger:    (&Logger{Guild: guildName}).With("guild_id", guildID),
+	}
+	links, err := store.Links(guildID)
+	if err != nil {
+		c.logger.Errorf("Unable to load saved profile links: %v", err)
+	}
+	c.links = links
+	c.logger.Printf("Loaded %d profile links from store", len(c.links))
+	if c.settings, err = store.Settings(guildID); err != nil {
+		c.logger.Errorf("Unable to load saved settings: %v", err)
+	}
+	return c
+}
+
+// Settings returns the guild settings, as saved with /config.
+// Use GuildSettings.WithDefaults to fill in the bot defaults.
+func (c *Cache) Settings() GuildSettings {
+	c.settingMu.Lock()
+	defer c.settingMu.Unlock()
+	return c.settings
+}
+
+// SetSettings saves the guild settings.
+func (c *Cache) SetSettings(settings GuildSettings) error {
+	c.settingMu.Lock()
+	defer c.settingMu.Unlock()
+	if err := c.store.PutSettings(c.guildID, settings); err != nil {
+		return err
+	}
+	c.settings = settings
+	return nil
+}
+
+// guildCacheFor returns the cache for the guild ID, creating one if needed.
+// The second return value is true when the cache was just created.
+func guildCacheFor(guildID, guildName string) (*Cache, bool) {
+	guildCacheMu.Lock()
+	defer guildCacheMu.Unlock()
+	if cache, ok := guildCache[guildID]; ok {
+		return cache, false
+	}
+	cache := NewCache(store, guildID, guildName)
+	guildCache[guildID] = cache
+	return cache, true
+}
+
+// Synced returns true if the profile links were loaded from
+// the #swgoh-gg channel at least once.
+func (c *Cache) Synced() bool {
+	g, ok := c.store.Guild(c.guildID)
+	return ok && !g.SyncedAt.IsZero()
+}
+
+// Link returns the default account linked to the user, if any.
+func (c *Cache) Link(discordUserID string) (*ProfileLink, bool) {
+	return c.Account(discordUserID, "")
+}
+
+// Account returns the user account with the given label.
+// If label is empty, returns the user default account.
+func (c *Cache) Account(discordUserID, label string) (*ProfileLink, bool) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for _, link := range c.links[discordUserID] {
+		if (label == "" && link.Default) || (label != "" && link.Label == label) {
+			cp := *link
+			return &cp, true
+		}
+	}
+	return nil, false
+}
+
+// AccountLabels returns the labels of all accounts linked to the user.
+func (c *Cache) AccountLabels(discordUserID string) (labels []string) {
+	for _, a := range c.Accounts(discordUserID) {
+		labels = append(labels, a.Label)
+	}
+	return labels
+}
+
+// Accounts returns all accounts linked to the user.
+func (c *Cache) Accounts(discordUserID string) (accounts []*ProfileLink) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for _, link := range c.links[discordUserID] {
+		cp := *link
+		accounts = append(accounts, &cp)
+	}
+	return accounts
+}
+
+// SetLink saves the profile link as one of the user accounts.
+// If the link has no label, the account with the same ally code or profile
+// is replaced, otherwise a new label is assigned: main for the first
+// account, then alt1, alt2 and so on. The first account is the default one.
+func (c *Cache) SetLink(link *ProfileLink) {
+	link.UpdatedAt = time.Now()
+	c.linksMu.Lock()
+	c.setAccounts(link.UserID, putLink(c.links[link.UserID], link))
+	c.linksMu.Unlock()
+}
+
+// putLink adds the link to the accounts, as described in SetLink.
+func putLink(accounts []*ProfileLink, link *ProfileLink) []*ProfileLink {
+	if link.Label == "" {
+		link.Label = nextAccountLabel(accounts, link)
+	}
+	replaced := false
+	for i := range accounts {
+		if accounts[i].Label == link.Label {
+			link.Default = link.Default || accounts[i].Default
+			accounts[i] = link
+			replaced = true
+		} else if link.Default {
+			accounts[i].Default = false
+		}
+	}
+	if !replaced {
+		accounts = append(accounts, link)
+	}
+	return accounts
+}
+
+// SetChannelLink saves a link posted in the profile links channel. Each user
+// has a single channel link, so a new post replaces the previous one, keeping
+// its label; only /register adds more accounts. Posts older than the current
+// channel link of the user are ignored.
+func (c *Cache) SetChannelLink(link *ProfileLink) {
+	link.UpdatedAt = time.Now()
+	c.linksMu.Lock()
+	if accounts, ok := putChannelLink(c.links[link.UserID], link); ok {
+		c.setAccounts(link.UserID, accounts)
+	}
+	c.linksMu.Unlock()
+}
+
+// putChannelLink adds the channel link to the accounts, as described in
+// SetChannelLink. Returns false if the link is older than the current one.
+func putChannelLink(accounts []*ProfileLink, link *ProfileLink) ([]*ProfileLink, bool) {
+	for _, a := range accounts {
+		if a.MessageID == "" {
+			continue
+		}
+		if olderMessage(link.MessageID, a.MessageID) {
+			return accounts, false
+		}
+		link.Label, link.Default = a.Label, a.Default
+	}
+	return putLink(accounts, link), true
+}
+
+// olderMessage returns true if the message ID a was created before b.
+// Discord IDs are snowflakes, that grow with time.
+func olderMessage(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// nextAccountLabel returns the label for a new link in the accounts list.
+func nextAccountLabel(accounts []*ProfileLink, link *ProfileLink) string {
+	if len(accounts) == 0 {
+		return defaultAccountLabel
+	}
+	used := make(map[string]bool)
+	for _, a := range accounts {
+		if (link.AllyCode != "" && a.AllyCode == link.AllyCode) ||
+			(link.Profile != "" && a.Profile == link.Profile) {
+			return a.Label
+		}
+		used[a.Label] = true
+	}
+	for i := 1; ; i++ {
+		if label := "alt" + strconv.Itoa(i); !used[label] {
+			return label
+		}
+	}
+}
+
+// setAccounts updates the user accounts in memory and in the store,
+// making sure one of them is the default. Must be called with linksMu held.
+func (c *Cache) setAccounts(discordUserID string, accounts []*ProfileLink) {
+	ensureDefault(accounts)
+	if len(accounts) == 0 {
+		delete(c.links, discordUserID)
+	} else {
+		c.links[discordUserID] = accounts
+	}
+	if err := c.store.PutLinks(c.guildID, discordUserID, accounts); err != nil {
+		c.logger.Errorf("Unable to save profile links for %v: %v", discordUserID, err)
+	}
+}
+
+// ensureDefault marks the first account as the default, if none is.
+func ensureDefault(accounts []*ProfileLink) {
+	for _, a := range accounts {
+		if a.Default {
+			return
+		}
+	}
+	if len(accounts) > 0 {
+		accounts[0].Default = true
+	}
+}
+
+// SetDefaultAccount marks the account with the given label as the user default.
+// Returns false if the user has no such account.
+func (c *Cache) SetDefaultAccount(discordUserID, label string) bool {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	accounts := c.links[discordUserID]
+	found := false
+	for _, a := range accounts {
+		found = found || a.Label == label
+	}
+	if !found {
+		return false
+	}
+	for _, a := range accounts {
+		a.Default = a.Label == label
+	}
+	c.setAccounts(discordUserID, accounts)
+	return true
+}
+
+// RemoveLink removes all accounts linked to the user.
+func (c *Cache) RemoveLink(discordUserID string) {
+	c.removeAccounts(discordUserID, func(*ProfileLink) bool { return true })
+}
+
+// RemoveAccount removes the user account with the given label.
+// Returns false if the user has no such account.
+func (c *Cache) RemoveAccount(discordUserID, label string) bool {
+	return c.removeAccounts(discordUserID, func(a *ProfileLink) bool {
+		return a.Label == label
+	}) > 0
+}
+
+// removeAccounts removes the user accounts matching fn.
+// Returns the number of accounts removed.
+func (c *Cache) removeAccounts(discordUserID string, fn func(*ProfileLink) bool) int {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	var kept []*ProfileLink
+	for _, a := range c.links[discordUserID] {
+		if !fn(a) {
+			kept = append(kept, a)
+		}
+	}
+	removed := len(c.links[discordUserID]) - len(kept)
+	if removed > 0 {
+		c.setAccounts(discordUserID, kept)
+	}
+	return removed
+}
+
+// ApplyLinkMessage updates the profile links from a single #swgoh-gg message.
+// The message replaces the channel link of the user, and edits that change
+// the mentioned user remove the link from the previous one.
+func (c *Cache) ApplyLinkMessage(m *discordgo.Message) {
+	link, ok := parseLinkMessage(m)
+	if !ok {
+		c.RemoveLinksFromMessages(m.ID)
+		return
+	}
+	for _, p := range c.linksFromMessage(m.ID) {
+		if p.UserID != link.UserID {
+			c.removeAccounts(p.UserID, func(a *ProfileLink) bool {
+				return a.MessageID == m.ID
+			})
+		}
+	}
+	c.SetChannelLink(link)
+	c.logger.Printf("> Linked %v[%v] as %v: allyCode:'%v'/profile:'%v'", m.Author, link.UserID, link.Label, link.AllyCode, link.Profile)
+}
+
+// linksFromMessage returns the links created by the message ID.
+func (c *Cache) linksFromMessage(messageID string) (links []*ProfileLink) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for _, accounts := range c.links {
+		for _, a := range accounts {
+			if a.MessageID == messageID {
+				cp := *a
+				links = append(links, &cp)
+			}
+		}
 	}
+	return links
+}
+
+// RemoveLinksFromMessages removes the profile links created by any of the
+// provided message IDs. Returns the number of links removed.
+func (c *Cache) RemoveLinksFromMessages(messageIDs ...string) int {
+	ids := make(map[string]bool)
+	for _, id := range messageIDs {
+		ids[id] = true
+	}
+	removed := 0
+	for _, userID := range c.users() {
+		n := c.removeAccounts(userID, func(a *ProfileLink) bool {
+			return a.MessageID != "" && ids[a.MessageID]
+		})
+		if n > 0 {
+			c.logger.Printf("> Unlinked %d accounts of %v: source message removed", n, userID)
+		}
+		removed += n
+	}
+	return removed
+}
+
+// users returns the IDs of all users with linked accounts.
+func (c *Cache) users() (users []string) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for userID := range c.links {
+		users = append(users, userID)
+	}
+	return users
 }
 
 // UserProfile returns the profile associated with the user.
 func (c *Cache) UserProfile(discordUserID string) (string, bool) {
-	profile, ok := c.profiles[discordUserID]
-	return profile, ok
+	link, ok := c.Link(discordUserID)
+	if !ok || link.Profile == "" {
+		return "", false
+	}
+	return link.Profile, true
 }
 
 // UserProfileIfEmpty attempts to load a user profile if the first argument
@@ -47,68 +381,171 @@ func (c *Cache) UserProfileIfEmpty(profile, discordUserID string) (string, bool)
 	return c.UserProfile(discordUserID)
 }
 
-// SetUserProfile stores the user profile in cache
+// SetUserProfile stores the user profile in cache, updating the default account.
 func (c *Cache) SetUserProfile(discordUserID, profile string) {
-	c.profilesMutex.Lock()
-	defer c.profilesMutex.Unlock()
-	c.profiles[discordUserID] = profile
+	link := c.defaultLinkCopy(discordUserID)
+	link.Profile = profile
+	c.SetLink(link)
 }
 
 // AllyCode returns the ally code for the provided user,
-// allowing the bot links from the old style to be compatible
-// and still used
+// if known. See ResolveAllyCode for the old style profile links.
 func (c *Cache) AllyCode(discordUserID string) (string, bool) {
-	c.logger.Infof("> Checking ally code for %v -> ", discordUserID)
-	allyCode, ok := c.allyCodes[discordUserID]
+	return c.AccountAllyCode(discordUserID, "")
+}
+
+// AccountAllyCode returns the ally code of the user account with the given label,
+// or of the default account if label is empty.
+func (c *Cache) AccountAllyCode(discordUserID, label string) (string, bool) {
+	link, ok := c.Account(discordUserID, label)
+	if !ok || link.AllyCode == "" {
+		return "", false
+	}
+	return link.AllyCode, true
+}
+
+// ResolveAllyCode returns the ally code of the user account like AccountAllyCode,
+// allowing the bot links from the old style to be compatible and still used:
+// the ally code of swgoh.gg profile links is looked up and saved.
+func (c *Cache) ResolveAllyCode(ctx context.Context, data PlayerDataSource, discordUserID, label string) (string, bool) {
+	logger := contextLogger(ctx, c.logger)
+	logger.Debugf("> Checking ally code for %v [%v] -> ", discordUserID, label)
+	link, ok := c.Account(discordUserID, label)
 	if !ok {
-		profile, ok := c.profiles[discordUserID]
-		if !ok {
-			return "", false
-		}
-		allyCode = swgohgg.NewClient(profile).AllyCode()
-		if allyCode != "" {
-			c.SetAllyCode(discordUserID, allyCode)
-			return allyCode, true
-		}
 		return "", false
 	}
+	if link.AllyCode != "" {
+		cacheRequests.Inc("ally_code", "hit")
+		return link.AllyCode, true
+	}
+	if link.Profile == "" {
+		return "", false
+	}
+	cacheRequests.Inc("ally_code", "miss")
+	allyCode, err := data.AllyCode(ctx, link.Profile)
+	if err != nil {
+		logger.Errorf("Unable to lookup ally code for %v: %v", link.Profile, err)
+		return "", false
+	}
+	link.AllyCode = allyCode
+	c.SetLink(link)
 	return allyCode, true
 }
 
-// SetAllyCode associates the current AllyCode with the provided user.
+// SetAllyCode associates the current AllyCode with the provided user,
+// updating the default account.
 func (c *Cache) SetAllyCode(discordUserID, allyCode string) {
-	c.allyCodesMutext.Lock()
-	defer c.allyCodesMutext.Unlock()
-	c.allyCodes[discordUserID] = allyCode
+	link := c.defaultLinkCopy(discordUserID)
+	link.AllyCode = allyCode
+	c.SetLink(link)
 }
 
-// ListProfiles list all profiles in the current guild.
-func (c *Cache) ListProfiles() (res []string) {
-	for _, v := range c.profiles {
-		res = append(res, v)
+// defaultLinkCopy returns a copy of the user default account,
+// or a new main account if the user has none.
+func (c *Cache) defaultLinkCopy(discordUserID string) *ProfileLink {
+	link, ok := c.Link(discordUserID)
+	if !ok {
+		return &ProfileLink{UserID: discordUserID, Label: defaultAccountLabel}
+	}
+	return link
+}
+
+// ListLinks returns all accounts linked in the current guild.
+func (c *Cache) ListLinks() (links []*ProfileLink) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for _, accounts := range c.links {
+		for _, link := range accounts {
+			cp := *link
+			links = append(links, &cp)
+		}
 	}
-	return res
+	return links
+}
+
+// LinkCount returns the number of linked accounts.
+func (c *Cache) LinkCount() (count int) {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	for _, accounts := range c.links {
+		count += len(accounts)
+	}
+	return count
 }
 
 // RemoveAllProfiles clear up all bot memories about profiles and users.
 func (c *Cache) RemoveAllProfiles() {
 	// Cleanup all profiles of the given guild
-	c.profilesMutex.Lock()
-	defer c.profilesMutex.Unlock()
-	c.profiles = make(map[string]string)
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	c.links = make(map[string][]*ProfileLink)
+	if err := c.store.ClearLinks(c.guildID); err != nil {
+		c.logger.Errorf("Unable to remove saved profile links: %v", err)
+	}
+}
+
+// replaceChannelLinks replaces all profile links that came from #swgoh-gg
+// messages with links, listed oldest first, keeping the ones registered with
+// the /register command. The links are changed at once, in memory and in the
+// store, and each user channel link keeps its previous label.
+func (c *Cache) replaceChannelLinks(links []*ProfileLink) error {
+	c.linksMu.Lock()
+	defer c.linksMu.Unlock()
+	all := make(map[string][]*ProfileLink)
+	previous := make(map[string]*ProfileLink)
+	for userID, accounts := range c.links {
+		for _, a := range accounts {
+			cp := *a
+			if a.MessageID != "" {
+				previous[userID] = &cp
+				continue
+			}
+			all[userID] = append(all[userID], &cp)
+		}
+	}
+	now := time.Now()
+	for _, link := range links {
+		link.UpdatedAt = now
+		accounts := all[link.UserID]
+		if p, ok := previous[link.UserID]; ok && !hasLabel(accounts, p.Label) {
+			link.Label, link.Default = p.Label, p.Default
+		}
+		if accounts, ok := putChannelLink(accounts, link); ok {
+			all[link.UserID] = accounts
+		}
+	}
+	for _, accounts := range all {
+		ensureDefault(accounts)
+	}
+	if err := c.store.ReplaceLinks(c.guildID, all); err != nil {
+		return err
+	}
+	c.links = all
+	return nil
+}
+
+// hasLabel returns true if one of the accounts has the label.
+func hasLabel(accounts []*ProfileLink, label string) bool {
+	for _, a := range accounts {
+		if a.Label == label {
+			return true
+		}
+	}
+	return false
 }
 
-// ReloadProfiles clears the profile cache and parse all messages in the
-// #swgoh-gg channel to associate users with profiles.
-func (c *Cache) ReloadProfiles(s *discordgo.Session) (int, string, error) {
+// ReloadProfiles parses all messages in the #swgoh-gg channel to associate
+// users with profiles, replacing the links from the channel once all
+// messages are read. Links registered with the /register command are kept.
+func (c *Cache) ReloadProfiles(s Session) (int, string, error) {
 	guild, err := s.Guild(c.guildID)
 	if err != nil {
 		return 0, "", err
 	}
-	c.RemoveAllProfiles()
 	c.logger.Printf("> Reloading profiles for guild %s#%s", guild.Name, guild.ID)
 
-	c.logger.Printf("> Looking up #swgoh-gg channel for guild %s", guild.Name)
+	settings := c.Settings()
+	c.logger.Printf("> Looking up %v channel for guild %s", settings.WithDefaults().RegistryChannel, guild.Name)
 	channels, err := s.GuildChannels(guild.ID)
 	if err != nil {
 		c.logger.Errorf("Loading channels. Skipping this guild (%v)", err)
@@ -117,19 +554,20 @@ func (c *Cache) ReloadProfiles(s *discordgo.Session) (int, string, error) {
 	c.logger.Infof("Found %d channels", len(channels))
 	chanID := ""
 	for _, ch := range channels {
-		if ch.Name == "swgoh-gg" {
+		if settings.IsRegistryChannel(ch) {
 			chanID = ch.ID
 			break
 		}
 	}
 	if chanID == "" {
-		c.logger.Errorf("No channel ID found with name #swgoh-gg. Skipping this guild.")
-		return 0, "", err
+		c.logger.Errorf("No channel ID found with name %v. Skipping this guild.", settings.WithDefaults().RegistryChannel)
+		return 0, "", fmt.Errorf("no %v channel found", formatChannel(settings.WithDefaults().RegistryChannel))
 	}
 	pageSize := 100
 	first := ""
 	last := ""
 	errors := ""
+	var links []*ProfileLink
 	for {
 		c.logger.Printf("Loading messages on #swgoh-gg(%v), last message ID: '%s'", chanID, last)
 		messages, err := s.ChannelMessages(chanID, pageSize, last, "", "")
@@ -141,45 +579,20 @@ func (c *Cache) ReloadProfiles(s *discordgo.Session) (int, string, error) {
 			c.logger.Errorf("Loading messages from #swgoh-gg channel: %v", err)
 			return 0, "", err
 		}
-		c.logger.Printf("> Currently with %d", len(messages))
+		c.logger.Debugf("> Currently with %d", len(messages))
 		for _, m := range messages {
 			if first == "" {
 				first = m.ID + ": " + m.Content
 			}
 			last = m.ID
-			c.logger.Printf("Parsing %v", m.Content)
-
-			// Associates the profile/allycode to the posting user...
-			id := m.Author.ID
-			// ... or with the mentioned one
-			if len(m.Mentions) != 0 && !m.MentionEveryone {
-				c.logger.Printf("* Using mentioned ID: %v", m.Mentions)
-				id = m.Mentions[0].ID
-			}
-
-			// We are interested into allyCodes only.
-			var allyCode, profile string
+			c.logger.Debugf("Parsing %v", m.Content)
 
-			allyCode = extractAllyCode(m.Content)
-			profile = extractProfile(m.Content)
-			if profile == "" && allyCode == "" {
+			link, ok := parseLinkMessage(m)
+			if !ok {
 				errors = errors + "\n" + m.Content
 				continue
 			}
-			// Let's try to fix some weird names, right?
-			aux, err := url.QueryUnescape(profile)
-			if err == nil {
-				// We could decode, so let's encode again in a better way.
-				profile = strings.Replace(url.QueryEscape(aux), "+", "%20", -1)
-			}
-
-			if profile != "" {
-				c.SetUserProfile(id, profile)
-			}
-			if allyCode != "" {
-				c.SetAllyCode(id, allyCode)
-			}
-			c.logger.Printf("> Linked %v[%v]: allyCode:'%v'/profile:'%v'", m.Author, m.Author.ID, allyCode, profile)
+			links = append(links, link)
 		}
 		if len(messages) < pageSize {
 			break
@@ -187,9 +600,58 @@ func (c *Cache) ReloadProfiles(s *discordgo.Session) (int, string, error) {
 		c.logger.Printf("> Waiting a bit to avoid doing a server overload...")
 		time.Sleep(1 * time.Second)
 	}
-	c.logger.Printf("Full profile list loaded %d + %d", len(c.profiles), len(c.allyCodes))
+	// Messages are listed newest first, so the last link posted by
+	// each user is the one kept.
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	if err := c.replaceChannelLinks(links); err != nil {
+		c.logger.Errorf("Unable to save the profile links: %v", err)
+		return 0, "", err
+	}
+	count := len(links)
+	c.logger.Printf("Full profile list loaded %d", count)
+	err = c.store.PutGuild(&GuildRecord{ID: guild.ID, Name: guild.Name, SyncedAt: time.Now()})
+	if err != nil {
+		c.logger.Errorf("Unable to save guild sync state: %v", err)
+	}
 	// c.logger.Printf("Ignored these invalid links:\n%v", errors)
-	return len(c.profiles), errors, nil
+	return count, errors, nil
+}
+
+// parseLinkMessage parses a #swgoh-gg message into a profile link.
+// The link is associated with the posting user, or with the first
+// mentioned one. Returns false if the message has no valid link.
+func parseLinkMessage(m *discordgo.Message) (*ProfileLink, bool) {
+	if m.Author == nil {
+		return nil, false
+	}
+	// Associates the profile/allycode to the posting user...
+	id := m.Author.ID
+	// ... or with the mentioned one
+	if len(m.Mentions) != 0 && !m.MentionEveryone {
+		id = m.Mentions[0].ID
+	}
+
+	// We are interested into allyCodes only.
+	allyCode := extractAllyCode(m.Content)
+	profile := extractProfile(m.Content)
+	if profile == "" && allyCode == "" {
+		return nil, false
+	}
+	// Let's try to fix some weird names, right?
+	aux, err := url.QueryUnescape(profile)
+	if err == nil {
+		// We could decode, so let's encode again in a better way.
+		profile = strings.Replace(url.QueryEscape(aux), "+", "%20", -1)
+	}
+	return &ProfileLink{
+		UserID:    id,
+		AllyCode:  allyCode,
+		Profile:   profile,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+	}, true
 }
 
 // profileRe is a regular expressions that allows one to extract the
@@ -206,7 +668,7 @@ func extractProfile(src string) string {
 	if len(results[0]) == 0 {
 		return ""
 	}
-	log.Printf("> Found profile '%v'", results[0][1])
+	logger.Debugf("> Found profile '%v'", results[0][1])
 	return results[0][1]
 }
 
@@ -220,7 +682,7 @@ func extractAllyCode(src string) string {
 	if len(results[0]) == 0 {
 		return ""
 	}
-	log.Printf("> Found ally code '%v'", results[0][1])
+	logger.Debugf("> Found ally code '%v'", results[0][1])
 	return results[0][1]
 }
 
@@ -242,12 +704,14 @@ func NewAPICache() *DiscordAPICache {
 }
 
 // GetGuild is a cached version of s.Guild()
-func (a *DiscordAPICache) GetGuild(s *discordgo.Session, guildID string) (*discordgo.Guild, error) {
+func (a *DiscordAPICache) GetGuild(s Session, guildID string) (*discordgo.Guild, error) {
 	a.guildsMu.Lock()
 	defer a.guildsMu.Unlock()
 	if g, ok := a.guilds[guildID]; ok {
+		cacheRequests.Inc("discord_guild", "hit")
 		return g, nil
 	}
+	cacheRequests.Inc("discord_guild", "miss")
 	g, err := s.Guild(guildID)
 	if g != nil {
 		a.guilds[guildID] = g
@@ -256,12 +720,14 @@ func (a *DiscordAPICache) GetGuild(s *discordgo.Session, guildID string) (*disco
 }
 
 // GetChannel is a cached version of s.Channel()
-func (a *DiscordAPICache) GetChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+func (a *DiscordAPICache) GetChannel(s Session, channelID string) (*discordgo.Channel, error) {
 	a.channelsMu.Lock()
 	defer a.channelsMu.Unlock()
 	if c, ok := a.channels[channelID]; ok {
+		cacheRequests.Inc("discord_channel", "hit")
 		return c, nil
 	}
+	cacheRequests.Inc("discord_channel", "miss")
 	c, err := s.Channel(channelID)
 	if c != nil {
 		a.channels[channelID] = c
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseLinkMessage(t *testing.T) {
+	testCases := []struct {
+		content  string
+		mention  string
+		ok       bool
+		userID   string
+		allyCode string
+		profile  string
+	}{
+		{content: "https://swgoh.gg/p/123456789/", ok: true, userID: "author", allyCode: "123456789"},
+		{content: "https://swgoh.gg/u/ronoaldo/", ok: true, userID: "author", profile: "ronoaldo"},
+		{content: "https://swgoh.gg/p/123456789/ <@mentioned>", mention: "mentioned", ok: true, userID: "mentioned", allyCode: "123456789"},
+		{content: "hello there", ok: false},
+	}
+	for i, tc := range testCases {
+		m := &discordgo.Message{
+			ID:        "msg",
+			ChannelID: "chan",
+			Content:   tc.content,
+			Author:    &discordgo.User{ID: "author"},
+		}
+		if tc.mention != "" {
+			m.Mentions = []*discordgo.User{{ID: tc.mention}}
+		}
+		link, ok := parseLinkMessage(m)
+		if ok != tc.ok {
+			t.Errorf("Test case #%d: unexpected ok=%v", i, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if link.UserID != tc.userID || link.AllyCode != tc.allyCode || link.Profile != tc.profile {
+			t.Errorf("Test case #%d: unexpected link %#v", i, link)
+		}
+		if link.MessageID != "msg" || link.ChannelID != "chan" {
+			t.Errorf("Test case #%d: missing source message in link %#v", i, link)
+		}
+	}
+}
+
+func TestApplyLinkMessage(t *testing.T) {
+	c := NewCache(nil, "guild", "Guild")
+	m := &discordgo.Message{
+		ID:      "msg1",
+		Content: "https://swgoh.gg/p/123456789/",
+		Author:  &discordgo.User{ID: "user1"},
+	}
+	c.ApplyLinkMessage(m)
+	if allyCode, ok := c.AllyCode("user1"); !ok || allyCode != "123456789" {
+		t.Errorf("Unexpected ally code after create: %v, %v", allyCode, ok)
+	}
+
+	// Editing the message to mention someone else moves the link
+	m.Content = "https://swgoh.gg/p/987654321/ <@user2>"
+	m.Mentions = []*discordgo.User{{ID: "user2"}}
+	c.ApplyLinkMessage(m)
+	if _, ok := c.Link("user1"); ok {
+		t.Errorf("Unexpected link for user1 after edit")
+	}
+	if allyCode, ok := c.AllyCode("user2"); !ok || allyCode != "987654321" {
+		t.Errorf("Unexpected ally code after edit: %v, %v", allyCode, ok)
+	}
+
+	// Links from other messages are kept on delete
+	c.SetLink(&ProfileLink{UserID: "user3", AllyCode: "111222333", MessageID: "msg3"})
+	if n := c.RemoveLinksFromMessages("msg1", "msg2"); n != 1 {
+		t.Errorf("Unexpected number of removed links: %d", n)
+	}
+	if _, ok := c.Link("user2"); ok {
+		t.Errorf("Unexpected link for user2 after delete")
+	}
+	if _, ok := c.Link("user3"); !ok {
+		t.Errorf("Missing link for user3 after unrelated delete")
+	}
+}
+
+func TestChannelLinkReplaced(t *testing.T) {
+	c := NewCache(nil, "guild", "Guild")
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "333333333", Label: "farm"})
+	c.ApplyLinkMessage(&discordgo.Message{ID: "100", Content: "https://swgoh.gg/p/111111111/", Author: &discordgo.User{ID: "user"}})
+	// A new post with the typo fixed replaces the channel link
+	c.ApplyLinkMessage(&discordgo.Message{ID: "101", Content: "https://swgoh.gg/p/111111112/", Author: &discordgo.User{ID: "user"}})
+	// Edits to older posts are ignored
+	c.ApplyLinkMessage(&discordgo.Message{ID: "99", Content: "https://swgoh.gg/p/999999999/", Author: &discordgo.User{ID: "user"}})
+
+	labels := c.AccountLabels("user")
+	if len(labels) != 2 || labels[0] != "farm" || labels[1] != "alt1" {
+		t.Fatalf("Unexpected account labels: %v", labels)
+	}
+	if a, _ := c.Account("user", "alt1"); a.AllyCode != "111111112" || a.MessageID != "101" {
+		t.Errorf("Unexpected channel link: %#v", a)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	c := NewCache(nil, "guild", "Guild")
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "111111111"})
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "222222222"})
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "333333333", Label: "farm"})
+	// Same ally code updates the existing account
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "222222222", Name: "Alt"})
+
+	labels := c.AccountLabels("user")
+	if len(labels) != 3 || labels[0] != "main" || labels[1] != "alt1" || labels[2] != "farm" {
+		t.Fatalf("Unexpected account labels: %v", labels)
+	}
+	if allyCode, _ := c.AllyCode("user"); allyCode != "111111111" {
+		t.Errorf("Unexpected default ally code: %v", allyCode)
+	}
+	if a, _ := c.Account("user", "alt1"); a.Name != "Alt" {
+		t.Errorf("Unexpected alt1 account: %#v", a)
+	}
+
+	if !c.SetDefaultAccount("user", "farm") {
+		t.Fatalf("Unable to change default account")
+	}
+	if allyCode, _ := c.AllyCode("user"); allyCode != "333333333" {
+		t.Errorf("Unexpected default ally code after change: %v", allyCode)
+	}
+
+	// Removing the default account promotes the first one
+	if !c.RemoveAccount("user", "farm") {
+		t.Fatalf("Unable to remove account")
+	}
+	if a, ok := c.Link("user"); !ok || a.Label != "main" {
+		t.Errorf("Unexpected default account after remove: %#v", a)
+	}
+	if c.RemoveAccount("user", "farm") {
+		t.Errorf("Unexpected removal of missing account")
+	}
+}
+
+func TestReloadProfiles(t *testing.T) {
+	st := newTestStore(t)
+	c := NewCache(st, "reload-guild", "Guild")
+	c.SetLink(&ProfileLink{UserID: "user", AllyCode: "333333333", Label: "farm"})
+	c.ApplyLinkMessage(&discordgo.Message{ID: "100", Content: "https://swgoh.gg/p/111111111/", Author: &discordgo.User{ID: "user"}})
+
+	// Without the registry channel, the links are kept
+	s := NewFakeSession()
+	s.AddGuild(&discordgo.Guild{ID: "reload-guild", Name: "Guild"})
+	if _, _, err := c.ReloadProfiles(s); err == nil {
+		t.Errorf("Expected error without the registry channel")
+	}
+	if labels := c.AccountLabels("user"); len(labels) != 2 {
+		t.Fatalf("Unexpected links after a failed reload: %v", labels)
+	}
+
+	s.AddGuild(&discordgo.Guild{ID: "reload-guild", Name: "Guild"}, &discordgo.Channel{ID: "registry", Name: "swgoh-gg"})
+	s.History["registry"] = []*discordgo.Message{
+		{ID: "102", Content: "https://swgoh.gg/p/111111112/", Author: &discordgo.User{ID: "user"}},
+		{ID: "101", Content: "https://swgoh.gg/p/444444444/", Author: &discordgo.User{ID: "other"}},
+		{ID: "100", Content: "https://swgoh.gg/p/111111111/", Author: &discordgo.User{ID: "user"}},
+	}
+	if n, _, err := c.ReloadProfiles(s); err != nil || n != 3 {
+		t.Fatalf("Unexpected reload result: %v, %v", n, err)
+	}
+	for _, cache := range []*Cache{c, NewCache(st, "reload-guild", "Guild")} {
+		labels := cache.AccountLabels("user")
+		if len(labels) != 2 || labels[0] != "farm" || labels[1] != "alt1" {
+			t.Fatalf("Unexpected account labels: %v", labels)
+		}
+		if a, _ := cache.Account("user", "alt1"); a.AllyCode != "111111112" {
+			t.Errorf("Unexpected channel link: %#v", a)
+		}
+		if allyCode, ok := cache.AllyCode("other"); !ok || allyCode != "444444444" {
+			t.Errorf("Unexpected link for other: %v %v", allyCode, ok)
+		}
+	}
+}
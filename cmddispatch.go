@@ -1,29 +1,41 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/ronoaldo/swgoh/swgohgg"
 )
 
 var (
-	errProfileRequered = errors.New("ap-5r: profile required for this command")
-	allyCodeRe         = regexp.MustCompile("^[0-9]{3,3}-?[0-9]{3,3}-?[0-9]{3,3}$")
+	errProfileRequered  = errors.New("ap-5r: profile required for this command")
+	errPermissionDenied = errors.New("ap-5r: permission denied for this command")
+	errRateLimited      = errors.New("ap-5r: rate limit exceeded for this command")
+	errTimeout          = errors.New("ap-5r: timeout running this command")
+	errShuttingDown     = errors.New("ap-5r: shutting down, not accepting commands")
+	allyCodeRe          = regexp.MustCompile("^[0-9]{3,3}-?[0-9]{3,3}-?[0-9]{3,3}$")
 )
 
 // CmdRequest holds parsed data from the context of a MessageCreate event.
 type CmdRequest struct {
-	s       *discordgo.Session
-	m       *discordgo.MessageCreate
-	l       *Logger
-	guild   *discordgo.Guild
-	channel *discordgo.Channel
-	cache   *Cache
-	args    *Args
+	id       string
+	ctx      context.Context
+	s        Session
+	m        *discordgo.MessageCreate
+	l        *Logger
+	guild    *discordgo.Guild
+	channel  *discordgo.Channel
+	cache    *Cache
+	data     PlayerDataSource
+	settings GuildSettings
+	cmd      *Command
+	args     *Args
 	// profile   string
 	// profileOk bool
 	allyCode   string
@@ -43,137 +55,284 @@ func (f CmdFunc) HandleCommand(c CmdRequest) error {
 	return f(c)
 }
 
+// HasPermission returns true if the message author has at least the
+// permission level perm in the current server.
+func (r CmdRequest) HasPermission(perm Permission) bool {
+	return userPermission(r.s, r.guild, r.m.ChannelID, r.settings, r.m.Author.ID, perm)
+}
+
+// Command is a bot command registered in the dispatcher, with the
+// metadata used to build the /help messages.
+type Command struct {
+	// Name is the main command name, typed after the prefix.
+	Name string
+	// Aliases are other names for the same command.
+	Aliases []string
+	// Usage describes the command arguments, like "*character*".
+	Usage string
+	// Description is a short, single line description of the command.
+	Description string
+	// Details is an optional longer text, displayed by /help command.
+	Details string
+	// Flags are the +flags accepted by the command.
+	Flags []string
+	// Examples are command lines, without the prefix.
+	Examples []string
+	// ProfileArg is true if the command accepts a [profile] argument.
+	ProfileArg bool
+	// Perm is the permission level required to run the command.
+	Perm Permission
+	// Cost is the number of tokens the command takes from the user,
+	// channel and server quotas. Defaults to 1.
+	Cost int
+	// Cooldown is the minimum interval between two uses of the command
+	// in the CooldownScope.
+	Cooldown      time.Duration
+	CooldownScope Scope
+	// Timeout is the maximum time to handle the command. Defaults to -cmd-timeout.
+	Timeout time.Duration
+	// Hidden commands are not listed in /help.
+	Hidden bool
+	// Handler is called to handle the command.
+	Handler CmdHandler
+}
+
+// usage returns the command name and arguments, formatted for display.
+func (c *Command) usage(prefix string) string {
+	if c.Usage == "" {
+		return fmt.Sprintf("**%s%s**", prefix, c.Name)
+	}
+	return fmt.Sprintf("**%s%s** %s", prefix, c.Name, c.Usage)
+}
+
+// Help returns the detailed help message of the command.
+func (c *Command) Help(prefix string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s: %s\n", c.usage(prefix), c.Description)
+	if c.Details != "" {
+		fmt.Fprintf(&b, "%s\n", c.Details)
+	}
+	if len(c.Aliases) > 0 {
+		fmt.Fprintf(&b, "*Aliases*: %s%s\n", prefix, strings.Join(c.Aliases, ", "+prefix))
+	}
+	if len(c.Flags) > 0 {
+		fmt.Fprintf(&b, "*Flags*: %s\n", strings.Join(c.Flags, ", "))
+	}
+	if c.Perm > PermEveryone {
+		fmt.Fprintf(&b, "*Restricted to* %s.\n", c.Perm)
+	}
+	if c.Cooldown > 0 {
+		fmt.Fprintf(&b, "*Cooldown*: once every %v per %v.\n", c.Cooldown, c.CooldownScope)
+	}
+	for _, e := range c.Examples {
+		fmt.Fprintf(&b, "*Example*: `%s%s`\n", prefix, e)
+	}
+	return b.String()
+}
+
+// reply sends the command result to the channel, unless the request
+// context is done, as the user was already told that the command failed.
+func (r CmdRequest) reply(message *discordgo.MessageSend) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	_, err := r.s.ChannelMessageSendComplex(r.m.ChannelID, message)
+	return err
+}
+
 // CmdDispatcher parses a MessageCreate and dispatches the request to the target command.
 type CmdDispatcher struct {
-	prefix string
-	cmds   map[string]CmdHandler
+	data        PlayerDataSource
+	cmds        map[string]*Command
+	list        []*Command
+	middlewares []Middleware
+
+	mu       sync.Mutex
+	draining bool
+	running  sync.WaitGroup
 }
 
-// NewDispatcher creates a new command dispatcher.
+// NewDispatcher creates a new command dispatcher,
+// with the default middlewares installed.
 func NewDispatcher() *CmdDispatcher {
-	return &CmdDispatcher{
-		cmds: make(map[string]CmdHandler),
+	d := &CmdDispatcher{
+		cmds: make(map[string]*Command),
 	}
+	d.Use(defaultMiddlewares...)
+	return d
+}
+
+// Use adds middlewares to the dispatcher. Middlewares are called in the order
+// they were added, so the last one added is the closest to the command handler.
+func (d *CmdDispatcher) Use(mw ...Middleware) {
+	d.middlewares = append(d.middlewares, mw...)
+}
+
+// Handle maps a command name and its aliases to the command handler.
+func (d *CmdDispatcher) Handle(cmd *Command) {
+	d.cmds[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		d.cmds[alias] = cmd
+	}
+	d.list = append(d.list, cmd)
+}
+
+// Command returns the command registered with the name or alias.
+func (d *CmdDispatcher) Command(name string) (*Command, bool) {
+	cmd, ok := d.cmds[name]
+	return cmd, ok
 }
 
-// Handle maps a command name to a command handler
-func (d *CmdDispatcher) Handle(cmd string, handler CmdHandler) {
-	d.cmds[cmd] = handler
+// Commands returns all registered commands, in registration order.
+func (d *CmdDispatcher) Commands() []*Command {
+	return d.list
 }
 
 // Dispatch parses the message and if a command is found, forwards the command to the handler.
 // If no handler is mapped, returns an error. If no command is detected, discards the event.
-func (d *CmdDispatcher) Dispatch(s *discordgo.Session, m *discordgo.MessageCreate) error {
+func (d *CmdDispatcher) Dispatch(s Session, m *discordgo.MessageCreate) error {
 	// Skip messages from self or non-command messages
-	if m.Author.ID == s.State.User.ID {
+	if m.Author.ID == s.BotUser().ID {
 		return nil
 	}
+	channel, guild, cache, ok := d.lookup(s, m)
+	if !ok {
+		return nil
+	}
+	settings := cache.Settings().WithDefaults()
+	// If message is from swgoh-gg, update the profile links.
+	if settings.IsRegistryChannel(channel) {
+		cache.ApplyLinkMessage(m.Message)
+		if strings.HasPrefix(m.Content, settings.Prefix) {
 
+			send(s, m.ChannelID, "Sorry, let's keep this channel for profile links only!")
+		}
+		return nil
+	}
+	// Discard non-commands
+	if !strings.HasPrefix(m.Content, settings.Prefix) {
+		return nil
+	}
+	// Build the CmdRequest
+	args := ParseCommand(m.Content, settings.Prefix)
+	// Only answer on bot channels, but let admins fix the config anywhere
+	if !settings.IsBotChannel(channel) && args.Command != "config" {
+		return nil
+	}
+	return d.run(s, m, channel, guild, cache, settings, args)
+}
+
+// DispatchArgs forwards the already parsed command to the handler, like Dispatch.
+// It is used for slash commands, where the channels allowed are managed in Discord.
+func (d *CmdDispatcher) DispatchArgs(s Session, m *discordgo.MessageCreate, args *Args) error {
+	channel, guild, cache, ok := d.lookup(s, m)
+	if !ok {
+		return fmt.Errorf("dispatcher: unable to load channel %v", m.ChannelID)
+	}
+	return d.run(s, m, channel, guild, cache, cache.Settings().WithDefaults(), args)
+}
+
+// lookup loads the channel, server and server cache of the message,
+// loading the profile links from the registry channel the first time.
+func (d *CmdDispatcher) lookup(s Session, m *discordgo.MessageCreate) (*discordgo.Channel, *discordgo.Guild, *Cache, bool) {
 	// Load data from cache to prepare for command parsing.
 	channel, err := apiCache.GetChannel(s, m.ChannelID)
-	if err != nil && strings.HasPrefix(m.Content, *cmdPrefix) {
+	if err != nil && strings.HasPrefix(m.Content, conf().Prefix) {
 		logger.Errorf("Error loading channel: %v", err)
 		send(s, m.ChannelID, "Oh, no. This should not happen. Unable to identify channel for this message!")
-		return nil
+		return nil, nil, nil, false
 	}
 	if channel == nil {
-		logger.Errorf("Unexpected error loading channel for message %v: %v (channel=%v)", m, err, channel)
-		return nil
+		logger.Errorf("Unexpected error loading channel for message %v: %v", m.ID, err)
+		return nil, nil, nil, false
 	}
 
 	guild, err := apiCache.GetGuild(s, channel.GuildID)
-	if err != nil && strings.HasPrefix(m.Content, *cmdPrefix) {
+	if err != nil && strings.HasPrefix(m.Content, conf().Prefix) {
 		logger.Errorf("Error loading channel: %v", err)
 		send(s, m.ChannelID, "Oh, no. This should not happen. Unable to identify server for this message!")
-		return nil
+		return nil, nil, nil, false
 	}
 	if guild == nil {
-		logger.Errorf("Unexpected error loading guild for message %v: %v (guild=%v)", m, err, guild)
-		return nil
+		logger.Errorf("Unexpected error loading guild for message %v: %v", m.ID, err)
+		return nil, nil, nil, false
 	}
-	logger := &Logger{Guild: guild.Name}
-	cache, ok := guildCache[channel.GuildID]
-	if !ok {
-		logger.Printf("No cache for guild ID %s, initializing one", channel.GuildID)
-		// Initialize new cache and build guild profile cache
-		cache = NewCache(channel.GuildID, guild.Name)
+	cache, created := guildCacheFor(channel.GuildID, guild.Name)
+	if created && !cache.Synced() {
+		// Never seen this guild before, build the profile links from #swgoh-gg
+		logger.Printf("No saved profiles for guild ID %s, loading from channel", channel.GuildID)
 		cache.ReloadProfiles(s)
-		guildCache[channel.GuildID] = cache
 	}
-	// If message is from swgoh-gg, reload profiles.
-	if channel.Name == "swgoh-gg" {
-		cache.ReloadProfiles(s)
-		if strings.HasPrefix(m.Content, *cmdPrefix) {
+	return channel, guild, cache, true
+}
 
-			send(s, m.ChannelID, "Sorry, let's keep this channel for profile links only!")
-		}
-		return nil
-	}
-	// Discard non-commands
-	if !strings.HasPrefix(m.Content, *cmdPrefix) {
+// Drain stops accepting new commands and waits until the running ones
+// are finished, or ctx is done.
+func (d *CmdDispatcher) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
 		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	// Log RECV and react to command
-	logger.Printf("RECV: (#%v) %v: %v", channel.Name, m.Author, m.Content)
-	s.MessageReactionAdd(m.ChannelID, m.ID, emojiHourGlassNotDone)
+// Draining returns true after Drain is called.
+func (d *CmdDispatcher) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
 
-	// Build the CmdRequest
-	args := ParseArgs(m.Content)
-
-	var allyCode string
-	var allyCodeOk bool
-	// If we got an ally code, use it
-	if args.Profile != "" {
-		// User passed explicitly. Check if it is ally code or not
-		if allyCodeRe.MatchString(args.Profile) {
-			// Use the provided ally code
-			allyCode = args.Profile
-			allyCodeOk = true
-		} else {
-			// Lookup the ally code from the profile
-			allyCode = swgohgg.NewClient(args.Profile).AllyCode()
-			allyCodeOk = allyCode != ""
-		}
-	} else {
-		// User passed implicitly. Check if we had discovered ally code yet
-		discordUserID := m.Author.ID
-		if len(m.Mentions) > 0 {
-			discordUserID = m.Mentions[0].ID
-		}
-		allyCode, allyCodeOk = cache.AllyCode(discordUserID)
+// begin registers a running command, unless the dispatcher is draining.
+func (d *CmdDispatcher) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
 	}
+	d.running.Add(1)
+	return true
+}
 
-	req := CmdRequest{
-		s:          s,
-		m:          m,
-		l:          logger,
-		guild:      guild,
-		channel:    channel,
-		cache:      cache,
-		args:       args,
-		allyCode:   allyCode,
-		allyCodeOk: allyCodeOk,
-	}
-
-	// Call the CmdHandler
-	h, ok := d.cmds[args.Command]
+// run builds the CmdRequest and calls the command handler with the middlewares.
+func (d *CmdDispatcher) run(s Session, m *discordgo.MessageCreate, channel *discordgo.Channel, guild *discordgo.Guild,
+	cache *Cache, settings GuildSettings, args *Args) error {
+	if !d.begin() {
+		return errShuttingDown
+	}
+	defer d.running.Done()
+	id := randomID()
+	logger := (&Logger{Guild: guild.Name}).With(
+		"request", id, "guild_id", guild.ID, "channel", channel.ID, "user", m.Author.ID, "command", args.Command)
+	cmd, ok := d.Command(args.Command)
 	if !ok {
+		logger.Printf("RECV: (#%v) %v: %v", channel.Name, m.Author, m.Content)
 		s.MessageReactionAdd(m.ChannelID, m.ID, emojiQuestionMark)
 		return fmt.Errorf("dispatcher: no command mapped to %v", args.Command)
 	}
-
-	// Hadle command and react with result
-	logger.Infof("Dispatching command %#v", req)
-	err = h.HandleCommand(req)
-	result := emojiCheckMark
-	if err == errProfileRequered {
-		s.MessageReactionAdd(m.ChannelID, m.ID, emojiFacePalm)
-		askForProfile(s, m, args.Command)
-		return nil
-	} else if err != nil {
-		result = emojiCrossMark
+	req := CmdRequest{
+		id:       id,
+		ctx:      withLanguage(withLogger(context.Background(), logger), settings.Language),
+		s:        s,
+		m:        m,
+		l:        logger,
+		guild:    guild,
+		channel:  channel,
+		cache:    cache,
+		data:     d.data,
+		settings: settings,
+		cmd:      cmd,
+		args:     args,
 	}
-	s.MessageReactionAdd(m.ChannelID, m.ID, result)
-	return err
+	return Chain(cmd.Handler, d.middlewares...).HandleCommand(req)
 }
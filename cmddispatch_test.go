@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDispatcherAliases(t *testing.T) {
+	d := NewDispatcher()
+	d.Handle(&Command{Name: "stats", Aliases: []string{"info"}, Description: "display stats."})
+	d.Handle(&Command{Name: "hidden", Hidden: true})
+
+	for _, name := range []string{"stats", "info"} {
+		cmd, ok := d.Command(name)
+		if !ok || cmd.Name != "stats" {
+			t.Errorf("Unexpected command for %s: %v (ok=%v)", name, cmd, ok)
+		}
+	}
+	if _, ok := d.Command("missing"); ok {
+		t.Errorf("Unexpected command found for missing")
+	}
+	if l := len(d.Commands()); l != 2 {
+		t.Errorf("Unexpected command list size: %d, expected 2", l)
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	cmd := &Command{
+		Name:        "stats",
+		Aliases:     []string{"info"},
+		Usage:       "*character*",
+		Description: "display character basic stats.",
+		Flags:       []string{"+ships"},
+		Examples:    []string{"stats rey"},
+		Perm:        PermOfficer,
+	}
+	help := cmd.Help("!")
+	for _, expected := range []string{"**!stats** *character*", "!info", "+ships", "`!stats rey`", "a guild officer"} {
+		if !strings.Contains(help, expected) {
+			t.Errorf("Help message missing %q:\n%s", expected, help)
+		}
+	}
+}
+
+func TestDispatcherDrain(t *testing.T) {
+	h := newTestHarness(t)
+	started, release := make(chan bool), make(chan bool)
+	h.d.Handle(&Command{Name: "slow", Handler: CmdFunc(func(c CmdRequest) error {
+		started <- true
+		<-release
+		return nil
+	})})
+	done := make(chan error)
+	go func() {
+		done <- h.send("user", "/slow")
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.d.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error draining with a running command: %v", err)
+	}
+	if err := h.send("user", "/help"); err != errShuttingDown {
+		t.Errorf("Unexpected error running a command while draining: %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error from the running command: %v", err)
+	}
+	if err := h.d.Drain(context.Background()); err != nil {
+		t.Errorf("Unexpected error draining: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"sort"
 	"strconv"
@@ -15,15 +14,6 @@ import (
 	"github.com/ronoaldo/swgoh/swgohhelp"
 )
 
-// cmdDisabled returns a message saying the command was disabled.
-func cmdDisabled(reason string) CmdHandler {
-	return CmdFunc(func(r CmdRequest) (err error) {
-		_, err = send(r.s, r.m.ChannelID, "Oh no! I am so sorry but **%s** command was disabled. "+
-			"It was not working properly because %s. :cry:", r.args.Command, reason)
-		return
-	})
-}
-
 // cmdMods display mods equiped on a character.
 func cmdMods(r CmdRequest) (err error) {
 	if !r.allyCodeOk {
@@ -35,14 +25,51 @@ func cmdMods(r CmdRequest) (err error) {
 		return nil
 	}
 	targetURL := fmt.Sprintf("https://swgoh.gg/p/%s/characters/%s", r.allyCode, swgohgg.CharSlug(swgoh.CharName(char)))
+	player, err := r.data.Player(r.ctx, r.allyCode)
+	if err != nil {
+		if conf().PageRenderFallback {
+			r.l.Errorf("Unable to load player, using PageRender: %v", err)
+			return cmdModsPageRender(r, char, targetURL)
+		}
+		send(r.s, r.m.ChannelID, "Oops, that did not work as expected: %v. I hope nothing is broken ....", err.Error())
+		return handled(err)
+	}
+	unit, ok := player.Roster.FindByName(swgoh.CharName(char))
+	if !ok {
+		send(r.s, r.m.ChannelID, "It looks like **%s** is not activated, is it %s?", char, r.m.Author.Mention())
+		return nil
+	}
+	if len(unit.Mods) == 0 {
+		send(r.s, r.m.ChannelID, "Hmm, **%s** has no mods. Time to visit the mod shop %s?", unit.Name, r.m.Author.Mention())
+		return nil
+	}
+	d := &drawer{player: *player, l: r.l}
+	b, err := d.DrawUnitMods(unit)
+	if err != nil {
+		if conf().PageRenderFallback {
+			r.l.Errorf("Unable to draw mods, using PageRender: %v", err)
+			return cmdModsPageRender(r, char, targetURL)
+		}
+		send(r.s, r.m.ChannelID, "Oh, no! I was unable to create the image :(")
+		return err
+	}
+	return r.reply(&discordgo.MessageSend{
+		Content: "Here is the thing you asked " + r.m.Author.Mention(),
+		Files:   newAttachment(b, fmt.Sprintf("%s - %s mods.png", unquote(player.Name), unit.Name)),
+	})
+}
+
+// cmdModsPageRender display mods equiped on a character using a
+// PageRender screenshot of the swgoh.gg character page.
+func cmdModsPageRender(r CmdRequest, char, targetURL string) (err error) {
 	querySelector := ".list-group.media-list.media-list-stream:nth-child(2)"
 	clickSelector := ".icon.icon-chevron-down.pull-left"
-	b, err := renderImageAt(r.l, targetURL, querySelector, clickSelector, "desktop")
+	b, err := renderImageAt(r.ctx, r.l, targetURL, querySelector, clickSelector, "desktop")
 	if err != nil {
 		send(r.s, r.m.ChannelID, "Oh, no! I was unable to create the image :(")
 		return err
 	}
-	_, err = r.s.ChannelMessageSendComplex(r.m.ChannelID, &discordgo.MessageSend{
+	return r.reply(&discordgo.MessageSend{
 		Content: "Here is the thing you asked " + r.m.Author.Mention(),
 		Embed: &discordgo.MessageEmbed{
 			Title: fmt.Sprintf("%s mods.jpg", swgoh.CharName(char)),
@@ -50,12 +77,11 @@ func cmdMods(r CmdRequest) (err error) {
 			Image: &discordgo.MessageEmbedImage{
 				URL: "attachment://image.jpg",
 			},
-			Color:  embedColor,
+			Color:  r.settings.Color(),
 			Footer: copyrightFooter,
 		},
 		Files: newAttachment(b, "image.jpg"),
 	})
-	return err
 }
 
 // cmdStats display character statistics.
@@ -68,16 +94,11 @@ func cmdStats(r CmdRequest) (err error) {
 		send(r.s, r.m.ChannelID, "Good, you are learning! But you need to provide a character name. Try /info tfp")
 		return nil
 	}
-	api := swgohhelp.New(context.Background())
-	if _, err := api.SignIn(*apiUser, *apiPass); err != nil {
-		return err
-	}
-	players, err := api.Players(r.allyCode)
+	player, err := r.data.Player(r.ctx, r.allyCode)
 	if err != nil {
 		send(r.s, r.m.ChannelID, "Oops, that did not work as expected: %v. I hope nothing is broken ....", err.Error())
-		return
+		return handled(err)
 	}
-	player := players[0]
 
 	charFilter := swgoh.CharName(char)
 	unit, ok := player.Roster.FindByName(charFilter)
@@ -85,6 +106,10 @@ func cmdStats(r CmdRequest) (err error) {
 		send(r.s, r.m.ChannelID, "It looks like **%s** is not activated, is it %s?", char, r.m.Author.Mention())
 		return
 	}
+	if unit.Stats == nil {
+		send(r.s, r.m.ChannelID, "Sorry %s, the stats of **%s** are not available from my data source. :cry:", r.m.Author.Mention(), unit.Name)
+		return nil
+	}
 	stats := unit.Stats.Final
 	char = swgoh.CharName(char)
 	funCharTitle := char
@@ -103,14 +128,14 @@ func cmdStats(r CmdRequest) (err error) {
 		funComment = " Oh wait, is this a turtle? Give it some speeeeed :rolling_eyes:"
 	}
 	embedURL := fmt.Sprintf("https://swgoh.gg/p/%s/collection/%s/", r.allyCode, swgohgg.CharSlug(char))
-	logger.Infof("Sending embed URL=%v", embedURL)
+	r.l.Debugf("Sending embed URL=%v", embedURL)
 	message := &discordgo.MessageSend{
 		Content: fmt.Sprintf("Wow, nice stats %s!%s", r.m.Author.Mention(), funComment),
 	}
-	d := &drawer{}
+	d := &drawer{l: r.l}
 	b, err := d.DrawCharacterStats(unit)
 	if err != nil {
-		logger.Errorf("Error drawing image: %v", err)
+		r.l.Errorf("Error drawing image: %v", err)
 		message.Embed = &discordgo.MessageEmbed{
 			Title: fmt.Sprintf("%s stats for %s", unquote(player.Name), funCharTitle),
 			URL:   embedURL,
@@ -129,86 +154,104 @@ func cmdStats(r CmdRequest) (err error) {
 				{"Special Damage", fmt.Sprintf("%d", stats.SpecialDamage), true},
 				{"Special Crit. Chan.", fmt.Sprintf("%.02f%%", stats.SpecialCriticalChance*100), true},
 			},
-			Color:  embedColor,
+			Color:  r.settings.Color(),
 			Footer: copyrightFooter,
 		}
 	} else {
 		message.Files = newAttachment(b, fmt.Sprintf("%s - %s.png", player.Name, unit.Name))
 	}
-	_, err = r.s.ChannelMessageSendComplex(r.m.ChannelID, message)
+	err = r.reply(message)
 	return err
 }
 
-// cmdArena display your arena team, statistics and chart.
+// cmdArena display your arena team and statistics.
 func cmdArena(r CmdRequest) (err error) {
 	if !r.allyCodeOk {
 		return errProfileRequered
 	}
-	url := fmt.Sprintf("https://swgoh.gg/p/%s/", r.allyCode)
-	querySelector := ".chart-arena"
-	b, err := renderImageAt(logger, url, querySelector, "", "ipad")
+	fleet := r.args.ContainsFlag("+fleet", "+ships", "+ship", "+s")
+	more := r.args.ContainsFlag("+more")
+	player, err := r.data.Player(r.ctx, r.allyCode)
 	if err != nil {
-		logger.Errorf("Unable to render image %v", err)
-		send(r.s, r.m.ChannelID, "Oh no! I was unable to render the image :O")
-		return
+		send(r.s, r.m.ChannelID, "Oh no! I was unable to fetch your profile for ally code '%s'. Please make sure the information is correct ", r.allyCode)
+		return handled(err)
+	}
+	rank, team, roles := arenaSquad(player, fleet)
+	if len(team) == 0 {
+		send(r.s, r.m.ChannelID, "Hmm, it looks like **%s** has no %s arena team yet.", unquote(player.Name), arenaKind(fleet))
+		return nil
 	}
-	gg := swgohgg.NewClient("").SetAllyCode(r.allyCode)
-	team, update, err := gg.Arena()
+	d := &drawer{player: *player, l: r.l}
+	b, err := d.DrawArenaSquad(rank, team, roles)
 	if err != nil {
-		logger.Errorf("Unable to fetch your arena team: %v", err)
-		send(r.s, r.m.ChannelID, "Oh no! I was unable to fetch your profile for ally code '%s'. Please make sure the information is correct ", r.allyCode)
+		r.l.Errorf("Unable to draw arena squad: %v", err)
+		send(r.s, r.m.ChannelID, "Oh no! I was unable to draw the image :O")
+		return err
 	}
 	embed := &discordgo.MessageEmbed{
 		URL: fmt.Sprintf("https://swgoh.gg/p/%s", r.allyCode),
 		Image: &discordgo.MessageEmbedImage{
-			URL: "attachment://image.jpg",
+			URL: "attachment://image.png",
 		},
-		Title:       fmt.Sprintf("%s current arena team", r.allyCode),
-		Description: fmt.Sprintf("*Updated at %v*", update.Format(time.Stamp)),
-		Color:       embedColor,
+		Title:       fmt.Sprintf("%s current %s arena team", unquote(player.Name), arenaKind(fleet)),
+		Description: fmt.Sprintf("Rank **#%d**", rank),
+		Color:       r.settings.Color(),
 		Footer:      copyrightFooter,
 	}
+	if updated := time.Time(player.UpdatedAt); !updated.IsZero() {
+		embed.Description += fmt.Sprintf(" *Updated at %v*", updated.Format(time.Stamp))
+	}
 	var moreMessage string
-	if !r.args.ContainsFlag("+more") {
+	if !more {
 		moreMessage = "\nTo see more stats just ask!  Add +more to your command."
 	}
-	var leaderIndicator string
-	var inline bool
-	for index, char := range team {
-		// Most arena team members get no leader indicator and are inline.
-		leaderIndicator = ""
-		inline = true
-
-		// But index 0 is the leader.. so setup the indicator and dont go inline.
-		if index == 0 {
-			leaderIndicator = "Leader - "
-			inline = false
-		}
-
-		var value string
-
-		// Are they looking for the expanded, "more" display?
-		if r.args.ContainsFlag("+more") {
-			value = fmt.Sprintf("Speed: %d\n", char.Speed)
-			value += fmt.Sprintf("Health: %d\n", char.Health)
-			value += fmt.Sprintf("Protection: %d (%d Total)\n", char.Protection, char.Health+char.Protection)
-			value += fmt.Sprintf("Crit Dmg: %.1f%%\n", char.CriticalDamage)
-			value += fmt.Sprintf("Crit Chance: %.1f%%\n", char.PhysicalCritChance)
-		} else {
-			value = fmt.Sprintf("%d *Spd*, %d *HP*, %d *Prot*", char.Speed, char.Health, char.Protection)
+	for index, unit := range team {
+		// Most arena team members get no role indicator and are inline,
+		// but the leader and the capital ship are displayed first.
+		roleIndicator := ""
+		inline := true
+		if role := roles[index].String(); role != "" {
+			roleIndicator = role + " - "
+			inline = roles[index] == swgohhelp.SquadUnitReinforcement
 		}
 		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
-			Name:   fmt.Sprintf("%s (%s%d* G%d)", char.Name, leaderIndicator, char.Stars, char.GearLevel),
-			Value:  value,
+			Name:   fmt.Sprintf("%s (%s%d* G%d)", unit.Name, roleIndicator, unit.Rarity, unit.Gear),
+			Value:  arenaUnitStats(&unit, more),
 			Inline: inline,
 		})
 	}
-	_, err = r.s.ChannelMessageSendComplex(r.m.ChannelID, &discordgo.MessageSend{
+	return r.reply(&discordgo.MessageSend{
 		Content: fmt.Sprintf("So, here is the team you asked for, %v. %s", r.m.Author.Mention(), moreMessage),
 		Embed:   embed,
-		Files:   newAttachment(b, "image.jpg"),
+		Files:   newAttachment(b, "image.png"),
 	})
-	return err
+}
+
+// arenaKind returns the display name for the squad or fleet arena.
+func arenaKind(fleet bool) string {
+	if fleet {
+		return "fleet"
+	}
+	return "squad"
+}
+
+// arenaUnitStats formats the unit stats for the arena embed.
+func arenaUnitStats(unit *swgohhelp.Unit, more bool) string {
+	if unit.Stats == nil {
+		return "*No stats available*"
+	}
+	stats := unit.Stats.Final
+	if !more {
+		return fmt.Sprintf("%d *Spd*, %d *HP*, %d *Prot*", stats.Speed, stats.Health, stats.Protection)
+	}
+	value := fmt.Sprintf("Speed: %d\n", stats.Speed)
+	value += fmt.Sprintf("Health: %d\n", stats.Health)
+	value += fmt.Sprintf("Protection: %d (%d Total)\n", stats.Protection, stats.Health+stats.Protection)
+	value += fmt.Sprintf("Crit Dmg: %.1f%%\n", stats.CriticalDamage*100)
+	value += fmt.Sprintf("Crit Chance: %.1f%%\n", stats.PhysicalCriticalChance*100)
+	value += fmt.Sprintf("Potency: %.1f%%\n", stats.Potency*100)
+	value += fmt.Sprintf("Tenacity: %.1f%%\n", stats.Tenacity*100)
+	return value
 }
 
 // cmdFaction display a faction of a player collection.
@@ -216,54 +259,71 @@ func cmdFaction(r CmdRequest) (err error) {
 	if !r.allyCodeOk {
 		return errProfileRequered
 	}
-	// Fetch ally code from profile nickname
-	filter := strings.ToLower(strings.TrimSpace(r.args.Name))
-	if filter == "" {
+	query := strings.TrimSpace(r.args.Name)
+	if query == "" {
 		send(r.s, r.m.ChannelID, "Please provide a faction! Try /faction Empire")
 		return
 	}
-	filter = strings.TrimSuffix(filter, "s")
-	filter = factionName(filter)
-	displayName := filter
-	if displayName == "Rebel" {
+	ships := r.args.ContainsFlag("+ships", "+ship", "+s")
+
+	data, err := r.data.GameData(r.ctx)
+	if err != nil {
+		send(r.s, r.m.ChannelID, "Oops, that did not work as expected: %v. I hope nothing is broken ....", err.Error())
+		return handled(err)
+	}
+	player, err := r.data.Player(r.ctx, r.allyCode)
+	if err != nil {
+		send(r.s, r.m.ChannelID, "Oops, that did not work as expected: %v. I hope nothing is broken ....", err.Error())
+		return handled(err)
+	}
+	faction, ok := findFaction(factionNames(data.Categories), query)
+	if !ok {
+		send(r.s, r.m.ChannelID, "Hmm, I don't know any faction named **%s**. Try /faction Empire", query)
+		return nil
+	}
+	displayName := faction
+	switch faction {
+	case "Rebel":
 		displayName = "Rebel Scum"
-	} else if displayName == "Imperial Trooper" {
+	case "Imperial Trooper":
 		displayName = "Empire's finest"
-	} else if displayName == "Resistance" {
+	case "Resistance":
 		displayName = "Tank Raid Kings"
 	}
-	sent, _ := send(r.s, r.m.ChannelID, "Checking **%s** units tagged **%s** ... This may take some time :clock130:", unquote(r.allyCode), displayName)
+	sent, _ := send(r.s, r.m.ChannelID, "Checking **%s** units tagged **%s** ... This may take some time :clock130:", unquote(player.Name), displayName)
 	defer cleanup(r.s, sent)
 
-	filter = strings.Replace(filter, " ", "+", -1)
-	if filter == "Rebel+Scum" || filter == "Terrorists" || filter == "Terrorist" {
-		filter = "Rebel"
+	list := factionUnits(player.Roster, data.Units, faction, ships)
+	if len(list) == 0 {
+		send(r.s, r.m.ChannelID, "There are no %s tagged **%s**.", unitKind(ships), displayName)
+		return nil
 	}
-	targetURL := fmt.Sprintf("https://swgoh.gg/p/%s/characters/?f=%s", r.allyCode, filter)
-	querySelector := ".collection-char-list"
-	if r.args.ContainsFlag("+ships", "+ship", "+s") {
-		targetURL = fmt.Sprintf("https://swgoh.gg/p/%s/ships/?f=%s", r.allyCode, filter)
+	unlocked := 0
+	for _, u := range list {
+		if u.Rarity > 0 {
+			unlocked++
+		}
 	}
-	b, err := renderImageAt(logger, targetURL, querySelector, "", "desktop")
+	d := &drawer{player: *player, l: r.l}
+	b, err := d.DrawUnitList(list)
 	if err != nil {
-		logger.Errorf("Error rendering image: %v", err)
-		send(r.s, r.m.ChannelID, "Oh no! That is not good. Could not render image :-/")
+		r.l.Errorf("Error drawing image: %v", err)
+		send(r.s, r.m.ChannelID, "Oh no! That is not good. Could not draw image :-/")
 		return
 	}
-	_, err = r.s.ChannelMessageSendComplex(r.m.ChannelID, &discordgo.MessageSend{
-		Content: "There we go " + r.m.Author.Mention(),
-		Embed: &discordgo.MessageEmbed{
-			Title: fmt.Sprintf("Characters tagged %s.jpg", displayName),
-			URL:   targetURL,
-			Image: &discordgo.MessageEmbedImage{
-				URL: "attachment://image.jpg",
-			},
-			Color:  embedColor,
-			Footer: copyrightFooter,
-		},
-		Files: newAttachment(b, "image.jpg"),
+	return r.reply(&discordgo.MessageSend{
+		Content: fmt.Sprintf("There we go %s, %d of %d %s tagged **%s** unlocked.",
+			r.m.Author.Mention(), unlocked, len(list), unitKind(ships), displayName),
+		Files: newAttachment(b, fmt.Sprintf("%s - %s.png", unquote(player.Name), faction)),
 	})
-	return err
+}
+
+// unitKind returns the display name for characters or ships.
+func unitKind(ships bool) string {
+	if ships {
+		return "ships"
+	}
+	return "characters"
 }
 
 // cmdServerInfo performs server-wide statistics
@@ -273,62 +333,57 @@ func cmdServerInfo(r CmdRequest) (err error) {
 		send(r.s, r.m.ChannelID, "Oh, there we go again. You need to provide me a character name. Try /server-info tfp")
 		return
 	}
-	guildProfiles := r.cache.ListProfiles()
+	guildLinks := r.cache.ListLinks()
 	sent, err := send(r.s, r.m.ChannelID, "Loading %d profiles in the server. This may take a while. "+
-		"Take some tea and bring me some oil please. :clock10:", len(guildProfiles))
+		"Take some tea and bring me some oil please. :clock10:", len(guildLinks))
 	defer cleanup(r.s, sent)
 	stars := make(map[int]int)
 	gear := make(map[int]int)
 	zetaCount := make(map[string]int)
 
 	total := 0
-	gg := swgohgg.NewClient("")
-	allZetas, err := gg.Zetas()
-	if err != nil {
-		send(r.s, r.m.ChannelID, "Warning: I'll be skipping zetas as I could not load them. Something is wrong probably. (err=%v)", err)
-	}
-	zetas := make([]swgohgg.Ability, 0)
-	for _, zeta := range allZetas {
-		if strings.ToLower(zeta.Character) == strings.ToLower(swgoh.CharName(char)) {
-			zetas = append(zetas, zeta)
-		}
-	}
 	errCount := 0
+	charName := swgoh.CharName(char)
 
 	var maxSpeed, avgSpeed, minSpeed int
 	minSpeed = 99999
-	for _, profile := range guildProfiles {
+	for _, link := range guildLinks {
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
 		// Fetch char info for each profile
-		gg.Profile(profile)
-		stats, err := gg.CharacterStats(char)
-		time.Sleep(100 * time.Millisecond)
+		player, err := loadLink(r, link)
 		if err != nil {
-			// if 404, the player just does not have him active?
-			//send(s, m.ChannelID, "Oops, stopped at %d: %v", i, err.Error())
-			logger.Errorf("Unable to fetch character %s for %s: %v", char, profile, err)
+			r.l.Errorf("Unable to fetch character %s for %s: %v", char, linkName(link), err)
 			errCount++
 			continue
 		}
-		stars[int(stats.Stars)]++
-		gear[int(stats.GearLevel)]++
-		if stats.Speed > maxSpeed {
-			maxSpeed = stats.Speed
+		unit, ok := player.Roster.FindByName(charName)
+		if !ok {
+			// The player just does not have him active
+			continue
 		}
-		if stats.Speed < minSpeed && stats.Speed > 0 {
-			minSpeed = stats.Speed
+		stars[unit.Rarity]++
+		gear[unit.Gear]++
+		if unit.Stats != nil {
+			speed := unit.Stats.Final.Speed
+			if speed > maxSpeed {
+				maxSpeed = speed
+			}
+			if speed < minSpeed && speed > 0 {
+				minSpeed = speed
+			}
+			avgSpeed += speed
 		}
-		avgSpeed += stats.Speed
-		for _, skill := range stats.Skills {
-			for _, zeta := range zetas {
-				if strings.ToLower(skill.Name) == strings.ToLower(zeta.Name) && skill.Level == 8 {
-					zetaCount[zeta.Name]++
-				}
+		for _, skill := range unit.Skills {
+			if skill.IsZeta && skill.Tier == 8 {
+				zetaCount[skill.Name]++
 			}
 		}
 		total++
 	}
 	var msg bytes.Buffer
-	fmt.Fprintf(&msg, "From %d %s players, %d have %s\n", len(guildProfiles), r.guild.Name, total, swgoh.CharName(char))
+	fmt.Fprintf(&msg, "From %d %s players, %d have %s\n", len(guildLinks), r.guild.Name, total, swgoh.CharName(char))
 	fmt.Fprintf(&msg, "\n*Stars:*\n")
 	for i := 7; i >= 1; i-- {
 		count, ok := stars[i]
@@ -355,11 +410,32 @@ func cmdServerInfo(r CmdRequest) (err error) {
 	fmt.Fprintf(&msg, "\n*Fun fact*\n")
 	fmt.Fprintf(&msg, "Average speed is %.02f, with the "+
 		"fastest at %d and the slowest at %d", float64(avgSpeed)/float64(total), maxSpeed, minSpeed)
-	logger.Printf("INFO: %d profiles seems to be down. Need to improve error detection.", errCount)
+	r.l.Warnf("%d profiles seems to be down. Need to improve error detection.", errCount)
 	_, err = send(r.s, r.m.ChannelID, msg.String())
 	return err
 }
 
+// loadLink loads the player data of a linked account. The ally code is
+// resolved from the swgoh.gg profile only if the account has none.
+func loadLink(r CmdRequest, link *ProfileLink) (*swgohhelp.Player, error) {
+	allyCode := link.AllyCode
+	if allyCode == "" {
+		var err error
+		if allyCode, err = r.data.AllyCode(r.ctx, link.Profile); err != nil {
+			return nil, err
+		}
+	}
+	return r.data.Player(r.ctx, allyCode)
+}
+
+// linkName returns the ally code or profile of the account, for logging.
+func linkName(link *ProfileLink) string {
+	if link.AllyCode != "" {
+		return link.AllyCode
+	}
+	return link.Profile
+}
+
 // cmdLookup performs server-wide character lookup.
 // Usefull for platoon assignments.
 func cmdLookup(r CmdRequest) (err error) {
@@ -372,7 +448,7 @@ func cmdLookup(r CmdRequest) (err error) {
 	if ships {
 		unit = swgoh.ShipName(unit)
 	}
-	guildProfiles := r.cache.ListProfiles()
+	guildLinks := r.cache.ListLinks()
 
 	minStar := 0
 	minGear := 0
@@ -405,7 +481,7 @@ func cmdLookup(r CmdRequest) (err error) {
 				return a == b
 			}
 		} else {
-			logger.Infof("Unknown flag: %v", flag)
+			r.l.Debugf("Unknown flag: %v", flag)
 		}
 	}
 	msg := fmt.Sprintf("Looking for profiles that have **%s**,", unit)
@@ -420,37 +496,33 @@ func cmdLookup(r CmdRequest) (err error) {
 	sent, _ := send(r.s, r.m.ChannelID, "%s", msg)
 	defer cleanup(r.s, sent)
 	lines := make([]string, 0)
-	for i := 0; i < len(guildProfiles); i++ {
-		user := guildProfiles[i]
-		logger.Infof("Parsing user #%d (%s)", i, user)
-		profile, err := GetProfile(user)
+	for i := 0; i < len(guildLinks); i++ {
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
+		user := linkName(guildLinks[i])
+		r.l.Debugf("Parsing user #%d (%s)", i, user)
+		player, err := loadLink(r, guildLinks[i])
+		if err == errProfileLoading {
+			r.l.Debugf("*** Loading in background: %v***", user)
+			loadingCount++
+			continue
+		}
 		if err != nil {
-			logger.Infof("> Error: %v", err)
+			r.l.Debugf("> Error: %v", err)
 			errCount++
 			continue
 		}
-		if profile == nil {
-			logger.Infof("*** Loading in background: %v***", err)
-			loadingCount++
+		u, ok := player.Roster.FindByName(unit)
+		if !ok {
 			continue
 		}
-		unitStars, unitGear := 0, 0
+		r.l.Debugf("> Unit: %v", u.Name)
+		unitStars, unitGear := u.Rarity, u.Gear
 		if ships {
-			s := profile.Ship(unit)
-			if s == nil {
-				continue
-			}
-			logger.Infof("> Unit: %v", s)
-			unitStars, unitGear = s.Stars, 12
-		} else {
-			c := profile.Character(unit)
-			if c == nil {
-				continue
-			}
-			logger.Infof("> Unit: %v", c)
-			unitStars, unitGear = c.Stars, c.Gear
+			unitGear = 12
 		}
-		ok := false
+		ok = false
 		switch {
 		case minStar > 0 && minGear > 0:
 			// Both filters provided
@@ -463,9 +535,9 @@ func cmdLookup(r CmdRequest) (err error) {
 			ok = unitStars > 0 && unitGear > 0
 		}
 		if ok {
-			logger.Infof("> Player has the unit")
+			r.l.Debugf("> Player has the unit")
 			resultCount++
-			lines = append(lines, fmt.Sprintf("**%s**", unquote(user)))
+			lines = append(lines, fmt.Sprintf("**%s**", player.Name))
 		}
 	}
 	msg = fmt.Sprintf("%d players have **%s** %v.", resultCount, unit, r.args.Flags)
@@ -501,9 +573,9 @@ func cmdLookup(r CmdRequest) (err error) {
 func cmdReloadProfiles(r CmdRequest) (err error) {
 	count, invalid, err := r.cache.ReloadProfiles(r.s)
 	if err != nil {
-		logger.Errorf("Error parsing profiles: %v", err)
-		send(r.s, r.m.ChannelID, "Oh no! We're doomed! Unable to read profiles!")
-		return
+		r.l.Errorf("Error parsing profiles: %v", err)
+		send(r.s, r.m.ChannelID, "Oh no! We're doomed! Unable to read profiles: %v", err)
+		return handled(err)
 	}
 	send(r.s, r.m.ChannelID, "Parsed profiles for the server. I found %d valid links.", count)
 	if invalid != "" && r.args.ContainsFlag("+v", "+verbose") {
@@ -512,6 +584,215 @@ func cmdReloadProfiles(r CmdRequest) (err error) {
 	return nil
 }
 
+// isCommandFlag returns true if any command uses the flag, like +ships,
+// so it can't be an account name.
+func isCommandFlag(flag string) bool {
+	for _, cmd := range dispatcher.Commands() {
+		for _, f := range cmd.Flags {
+			if strings.EqualFold(f, flag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cmdRegister links an ally code to the message author, or to the
+// mentioned user when called by a guild officer. An optional account
+// label, like main or alt1, can be provided after the ally code.
+func cmdRegister(r CmdRequest) (err error) {
+	var allyCode, label string
+	for _, field := range strings.Fields(r.args.Name) {
+		if allyCodeRe.MatchString(field) {
+			allyCode = nonDigits.ReplaceAllString(field, "")
+		} else {
+			label = strings.ToLower(field)
+		}
+	}
+	if allyCode == "" {
+		send(r.s, r.m.ChannelID, "%s, please tell me a valid ally code. Try this: /register 123-456-789", r.m.Author.Mention())
+		return nil
+	}
+	if label != "" && !accountLabelRe.MatchString(label) {
+		send(r.s, r.m.ChannelID, "%s, account names must be a single word with letters and numbers, like *main* or *alt1*.", r.m.Author.Mention())
+		return nil
+	}
+	if isCommandFlag("+" + label) {
+		send(r.s, r.m.ChannelID, "%s, *%s* is already used as +%s in my commands. Please pick another account name.", r.m.Author.Mention(), label, label)
+		return nil
+	}
+	user, ok := registerTarget(r)
+	if !ok {
+		return nil
+	}
+	player, err := r.data.Player(r.ctx, allyCode)
+	if err != nil {
+		send(r.s, r.m.ChannelID, "Oops, I could not find a player with ally code **%s**: %v", allyCode, err)
+		return handled(err)
+	}
+	link := &ProfileLink{
+		UserID:   user.ID,
+		Label:    label,
+		AllyCode: allyCode,
+		Name:     player.Name,
+	}
+	r.cache.SetLink(link)
+	_, err = send(r.s, r.m.ChannelID, "Got it! %s is now linked to **%s** (%s) as *%s*.",
+		user.Mention(), unquote(player.Name), allyCode, link.Label)
+	return err
+}
+
+// cmdUnregister removes the ally codes linked to the message author, or to the
+// mentioned user when called by a guild officer. If an account label is
+// provided, only that account is removed.
+func cmdUnregister(r CmdRequest) (err error) {
+	user, ok := registerTarget(r)
+	if !ok {
+		return nil
+	}
+	if _, ok := r.cache.Link(user.ID); !ok {
+		_, err = send(r.s, r.m.ChannelID, "%s has no ally code linked, nothing to forget.", user.Mention())
+		return err
+	}
+	label := strings.ToLower(strings.TrimSpace(r.args.Name))
+	if label == "" {
+		r.cache.RemoveLink(user.ID)
+		_, err = send(r.s, r.m.ChannelID, "Done. I no longer remember the ally codes of %s.", user.Mention())
+		return err
+	}
+	if !r.cache.RemoveAccount(user.ID, label) {
+		_, err = send(r.s, r.m.ChannelID, "%s has no account named *%s*. Try /accounts to see them.", user.Mention(), label)
+		return err
+	}
+	_, err = send(r.s, r.m.ChannelID, "Done. I no longer remember the *%s* account of %s.", label, user.Mention())
+	return err
+}
+
+// cmdAccounts lists the accounts linked to the message author, or to the
+// mentioned user. Use "/accounts default <label>" to change the default one.
+func cmdAccounts(r CmdRequest) (err error) {
+	fields := strings.Fields(strings.ToLower(r.args.Name))
+	if len(fields) == 2 && fields[0] == "default" {
+		user, ok := registerTarget(r)
+		if !ok {
+			return nil
+		}
+		if !r.cache.SetDefaultAccount(user.ID, fields[1]) {
+			_, err = send(r.s, r.m.ChannelID, "%s has no account named *%s*. Try /accounts to see them.", user.Mention(), fields[1])
+			return err
+		}
+		_, err = send(r.s, r.m.ChannelID, "Done. *%s* is now the default account of %s.", fields[1], user.Mention())
+		return err
+	}
+	user := r.m.Author
+	if len(r.m.Mentions) > 0 {
+		user = r.m.Mentions[0]
+	}
+	accounts := r.cache.Accounts(user.ID)
+	if len(accounts) == 0 {
+		_, err = send(r.s, r.m.ChannelID, "%s has no ally code linked. Try /register 123-456-789", user.Mention())
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "Accounts linked to %s:\n", user.Mention())
+	for _, a := range accounts {
+		id := a.AllyCode
+		if id == "" {
+			id = unquote(a.Profile)
+		}
+		fmt.Fprintf(&msg, "**%s**: %s", a.Label, id)
+		if a.Name != "" {
+			fmt.Fprintf(&msg, " (%s)", unquote(a.Name))
+		}
+		if a.Default {
+			fmt.Fprintf(&msg, " *default*")
+		}
+		fmt.Fprintf(&msg, "\n")
+	}
+	fmt.Fprintf(&msg, "\nUse +label or [label] in a command to pick an account, like /stats tfp +%s", accounts[len(accounts)-1].Label)
+	_, err = send(r.s, r.m.ChannelID, "%s", msg.String())
+	return err
+}
+
+// registerTarget returns the user to be (un)registered: the mentioned one if
+// the author is a guild officer, or the author itself.
+func registerTarget(r CmdRequest) (*discordgo.User, bool) {
+	if len(r.m.Mentions) == 0 || r.m.Mentions[0].ID == r.m.Author.ID {
+		return r.m.Author, true
+	}
+	if !r.HasPermission(PermOfficer) {
+		send(r.s, r.m.ChannelID, "Sorry %s, only guild officers can manage ally codes for other users.", r.m.Author.Mention())
+		return nil, false
+	}
+	return r.m.Mentions[0], true
+}
+
+// cmdConfig allows server admins to view and change the server settings.
+//
+//	/config [get [setting]]
+//	/config set setting value
+//	/config reset [setting]
+func cmdConfig(r CmdRequest) (err error) {
+	fields := strings.Fields(r.args.Name)
+	action, key, value := "get", "", ""
+	if len(fields) > 0 {
+		action = strings.ToLower(fields[0])
+	}
+	if len(fields) > 1 {
+		key = strings.ToLower(fields[1])
+	}
+	if len(fields) > 2 {
+		value = strings.Join(fields[2:], " ")
+	}
+	settings := r.cache.Settings()
+	switch action {
+	case "get":
+		keys := settingKeys
+		if key != "" {
+			keys = []string{key}
+		}
+		var msg bytes.Buffer
+		fmt.Fprintf(&msg, "Settings for **%s**:\n", r.guild.Name)
+		for _, k := range keys {
+			v, err := settings.Get(k)
+			if err != nil {
+				send(r.s, r.m.ChannelID, "Oops: %v. Available settings: %s", err, strings.Join(settingKeys, ", "))
+				return nil
+			}
+			fmt.Fprintf(&msg, "**%s**: %s\n", k, v)
+		}
+		_, err = send(r.s, r.m.ChannelID, "%s", msg.String())
+		return err
+	case "set":
+		if err := settings.Set(key, value); err != nil {
+			send(r.s, r.m.ChannelID, "Oops: %v. Try: %sconfig set prefix !", err, r.settings.Prefix)
+			return nil
+		}
+	case "reset":
+		if key == "" {
+			settings = GuildSettings{}
+		} else if err := settings.Reset(key); err != nil {
+			send(r.s, r.m.ChannelID, "Oops: %v. Available settings: %s", err, strings.Join(settingKeys, ", "))
+			return nil
+		}
+	default:
+		send(r.s, r.m.ChannelID, "Use %sconfig get, %sconfig set *setting* *value* or %sconfig reset *setting*. Available settings: %s",
+			r.settings.Prefix, r.settings.Prefix, r.settings.Prefix, strings.Join(settingKeys, ", "))
+		return nil
+	}
+	if err = r.cache.SetSettings(settings); err != nil {
+		send(r.s, r.m.ChannelID, "Oh no! I was unable to save the settings :(")
+		return err
+	}
+	if key == "" {
+		_, err = send(r.s, r.m.ChannelID, "Done. All settings are back to the defaults.")
+		return err
+	}
+	v, _ := settings.Get(key)
+	_, err = send(r.s, r.m.ChannelID, "Done. **%s** is now %s", key, v)
+	return err
+}
+
 // cmdshareThisBot displays information on how to share the bot.
 func cmdShareThisBot(r CmdRequest) (err error) {
 	msg := "AP-5R protocol droid is able to join other servers, but you need to follow this instructions:\n" +
@@ -522,14 +803,6 @@ func cmdShareThisBot(r CmdRequest) (err error) {
 	return nil
 }
 
-// cmdBotStats returns statistics about bot Guilds.
-// Not very useful, should be replaced once we use a database.
-func cmdBotStats(r CmdRequest) (err error) {
-	quant := listMyGuilds(r.s)
-	_, err = send(r.s, r.m.ChannelID, "Running on **%d** guilds", quant)
-	return err
-}
-
 // cmdLeaveGuild is an admin command to allow the bot to leave a guild.
 // Disabled by default, activate for development pourposes or maintanance.
 func cmdLeaveGuild(r CmdRequest) (err error) {
@@ -563,43 +836,62 @@ func cmdDebugImage(r CmdRequest) (err error) {
             FromMods: swgohhelp.UnitStatItems{},
         },
     }
-	d := &drawer{}
+	d := &drawer{l: r.l}
 	b, err := d.DrawCharacterStats(unit)
 	if err != nil {
-        logger.Errorf("Error drawing image: %v", err)
+        r.l.Errorf("Error drawing image: %v", err)
         return err
     } else {
 		message.Files = newAttachment(b, fmt.Sprintf("Test drawing - %s.png", unit.Name))
 	}
-	_, err = r.s.ChannelMessageSendComplex(r.m.ChannelID, message)
+	err = r.reply(message)
 	return err
 }
 
-// cmdHelp displays the help message.
+// cmdHelp displays the help message, built from the registered commands.
 func cmdHelp(req CmdRequest) (err error) {
-	m := "Hi **%s**, I'm AP-5R and I'm the Empire protocol droid unit that survived the Death Star destruction."
-	m += " While I understand many languages, please use the following commands to contact me in this secure channel:\n\n"
-
-	m += "**/arena**: display your current arena basic stats. Use +more to get more stats.\n"
-	m += "**/stats** *character*: display character basic stats.\n"
-	m += "**/mods** *character*: display the mods you have on a character.\n"
-	m += "**/faction**: display an image of your characters in the given faction." +
-		" *Add +ships, +ship or +s to get ship info.*\n\n"
-
-	m += "**/server-info** *character*: if you want me to do some number crunch and display server-wide stats about a character." +
-		" *Add +ships, +ship or +s to get ship info.*\n"
-	m += "**/lookup** *character*: to search and see who has a specific character." +
-		" *Add +1star .. +7star to filter by star level, and +g1 .. +g12 to filter by gear level.*" +
-		" *Add +ships, +ship or +s to get ship info.*\n\n"
-
-	m += "**/share-this-bot**: if you want my help in a galaxy far, far away...\n\n"
-
-	m += "I'll assume that all users shared their profile at the #swgoh-gg channel." +
-		" Please ask your server admin to create one." +
-		" This is important for me to properly function here, as I'll link the message author with the profile." +
-		" You can also share a profile on behalf of a shard-mate by @mentioning that player after the link." +
-		" Alternatively, you can use [profile] syntax at the end of /mods, /stats, /faction and /arena" +
-		" in order to get info from another profile than yours."
-	_, err = send(req.s, req.m.ChannelID, m, req.m.Author.Username)
+	prefix := req.settings.Prefix
+	if name := strings.TrimPrefix(strings.ToLower(req.args.Name), prefix); name != "" {
+		cmd, ok := dispatcher.Command(name)
+		if !ok || cmd.Hidden {
+			_, err = send(req.s, req.m.ChannelID, "Sorry %s, I don't know the command **%s**. Try %shelp.",
+				req.m.Author.Mention(), name, prefix)
+			return
+		}
+		if msg, disabled := commandDisabled(req.settings, cmd, name); disabled {
+			_, err = send(req.s, req.m.ChannelID, "%s", msg)
+			return
+		}
+		_, err = send(req.s, req.m.ChannelID, "%s", cmd.Help(prefix))
+		return
+	}
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "Hi **%s**, I'm AP-5R and I'm the Empire protocol droid unit that survived the Death Star destruction.", req.m.Author.Username)
+	fmt.Fprintf(&m, " While I understand many languages, please use the following commands to contact me in this secure channel:\n\n")
+	for _, cmd := range dispatcher.Commands() {
+		if _, disabled := commandDisabled(req.settings, cmd, cmd.Name); cmd.Hidden || disabled {
+			continue
+		}
+		fmt.Fprintf(&m, "%s: %s", cmd.usage(prefix), cmd.Description)
+		if cmd.Perm > PermEveryone {
+			fmt.Fprintf(&m, " *Only for %s.*", cmd.Perm)
+		}
+		fmt.Fprintf(&m, "\n")
+	}
+	fmt.Fprintf(&m, "\nUse %shelp *command* to learn more about a command.\n\n", prefix)
+
+	registry, _ := req.settings.Get("registry-channel")
+	fmt.Fprintf(&m, "I'll assume that all users shared their profile at the %s channel, or used %sregister."+
+		" Please ask your server admin to create one."+
+		" This is important for me to properly function here, as I'll link the message author with the profile."+
+		" You can also share a profile on behalf of a shard-mate by @mentioning that player after the link."+
+		" Alternatively, you can use [profile] syntax at the end of your commands"+
+		" in order to get info from another profile than yours.", registry, prefix)
+	for _, msg := range splitMessage(m.String(), maxReportSize) {
+		if _, err = send(req.s, req.m.ChannelID, "%s", msg); err != nil {
+			return
+		}
+	}
 	return
 }
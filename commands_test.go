@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+// testGuildCount makes the guild and channel IDs unique across tests,
+// as the Discord API cache and the guild caches are shared.
+var testGuildCount int32
+
+// testHarness feeds messages through a CmdDispatcher with all the bot
+// commands, using a FakeSession and a MemoryDataSource.
+type testHarness struct {
+	t       *testing.T
+	s       *FakeSession
+	d       *CmdDispatcher
+	data    *MemoryDataSource
+	cache   *Cache
+	channel *discordgo.Channel
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	n := atomic.AddInt32(&testGuildCount, 1)
+	h := &testHarness{
+		t:       t,
+		s:       NewFakeSession(),
+		d:       NewDispatcher(),
+		data:    NewMemoryDataSource(),
+		channel: &discordgo.Channel{ID: fmt.Sprintf("channel-%d", n), Name: "bots"},
+	}
+	guild := &discordgo.Guild{ID: fmt.Sprintf("guild-%d", n), Name: "Test Guild"}
+	h.s.AddGuild(guild, h.channel)
+	for _, cmd := range dispatcher.Commands() {
+		h.d.Handle(cmd)
+	}
+	h.d.data = h.data
+	h.cache, _ = guildCacheFor(guild.ID, guild.Name)
+	return h
+}
+
+// send dispatches the message content from the user ID, clearing
+// the previous replies and reactions.
+func (h *testHarness) send(userID, content string) error {
+	h.s.Reset()
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "message",
+		ChannelID: h.channel.ID,
+		Content:   content,
+		Author:    &discordgo.User{ID: userID, Username: userID},
+	}}
+	return h.d.Dispatch(h.s, m)
+}
+
+// replies returns the content of the messages sent.
+func (h *testHarness) replies() (replies []string) {
+	for _, m := range h.s.Sent {
+		replies = append(replies, m.Content)
+	}
+	return replies
+}
+
+// expectReaction fails the test if the last reaction to the message is not emoji.
+func (h *testHarness) expectReaction(emoji string) {
+	h.t.Helper()
+	if len(h.s.Reactions) == 0 {
+		h.t.Errorf("Expected reaction %v, got none", emoji)
+		return
+	}
+	if last := h.s.Reactions[len(h.s.Reactions)-1]; last.Emoji != emoji {
+		h.t.Errorf("Unexpected reaction %v, expected %v", last.Emoji, emoji)
+	}
+}
+
+// expectReply fails the test if no reply contains text.
+func (h *testHarness) expectReply(text string) {
+	h.t.Helper()
+	for _, r := range h.replies() {
+		if strings.Contains(r, text) {
+			return
+		}
+	}
+	h.t.Errorf("Expected a reply with %q, got %q", text, h.replies())
+}
+
+func TestCmdHelp(t *testing.T) {
+	h := newTestHarness(t)
+	if err := h.send("user", "/help"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.expectReaction(emojiCheckMark)
+	h.expectReply("/stats")
+	if strings.Contains(strings.Join(h.replies(), ""), "/leave-guild") {
+		t.Errorf("Unexpected hidden command in help: %v", h.replies())
+	}
+
+	h.send("user", "/help stats")
+	h.expectReply("info")
+
+	// Long help messages are split under the Discord limit
+	h.send(strings.Repeat("long-user-name-", 10), "/help")
+	if len(h.s.Sent) < 2 {
+		t.Errorf("Expected the help split in messages, got %d", len(h.s.Sent))
+	}
+	for _, r := range h.replies() {
+		if len(r) > 2000 {
+			t.Errorf("Help message with %d bytes is too long", len(r))
+		}
+	}
+}
+
+func TestCmdUnknown(t *testing.T) {
+	h := newTestHarness(t)
+	if err := h.send("user", "/not-a-command"); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+	h.expectReaction(emojiQuestionMark)
+	if len(h.s.Sent) != 0 {
+		t.Errorf("Unexpected replies: %v", h.replies())
+	}
+
+	h.send("user", "just chatting")
+	if len(h.s.Sent) != 0 || len(h.s.Reactions) != 0 {
+		t.Errorf("Unexpected replies to non-command: %v %v", h.replies(), h.s.Reactions)
+	}
+}
+
+func TestCmdStats(t *testing.T) {
+	h := newTestHarness(t)
+	h.data.AddPlayer(&swgohhelp.Player{
+		Name:     "Player",
+		AllyCode: 123456789,
+		Roster: swgohhelp.Roster{{
+			Name:   "Darth Vader",
+			Rarity: 7, Gear: 12, Level: 85,
+			Stats: &swgohhelp.UnitStats{
+				Final: swgohhelp.UnitStatItems{Health: 30000, Speed: 180},
+			},
+		}, {
+			// Units loaded from swgoh.gg have no stats
+			Name:   "Rey",
+			Rarity: 7, Gear: 12, Level: 85,
+		}},
+	})
+
+	h.send("user", "/stats darth vader")
+	h.expectReaction(emojiFacePalm)
+	h.expectReply("/register 123-456-789")
+
+	h.cache.SetAllyCode("user", "123456789")
+	if err := h.send("user", "/stats darth vader"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.expectReaction(emojiCheckMark)
+	h.expectReply("Wow, nice stats")
+	if len(h.s.Sent) != 1 || len(h.s.Sent[0].Files) != 1 || len(h.s.Sent[0].Files[0].Data) == 0 {
+		t.Errorf("Expected one image attachment, got %#v", h.s.Sent)
+	}
+
+	h.send("user", "/stats rey")
+	h.expectReaction(emojiCheckMark)
+	h.expectReply("stats of **Rey** are not available")
+
+	h.send("user", "/stats jyn")
+	h.expectReply("not activated")
+}
+
+func TestCmdLookup(t *testing.T) {
+	h := newTestHarness(t)
+	rey := swgohhelp.Roster{{Name: "Rey", Rarity: 7, Gear: 12, Level: 85}}
+	h.data.AddPlayer(&swgohhelp.Player{Name: "Registered", AllyCode: 123456789, Roster: rey})
+	h.data.AddPlayer(&swgohhelp.Player{Name: "Profile", AllyCode: 987654321, Roster: rey})
+	h.data.AllyCodes["someone"] = "987654321"
+	h.cache.SetAllyCode("lookup-registered", "123456789")
+	h.cache.SetLink(&ProfileLink{UserID: "lookup-profile", Profile: "someone"})
+
+	if err := h.send("lookup-user", "/lookup rey"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.expectReply("2 players have **rey**")
+	h.expectReply("**Profile**\n**Registered**")
+}
+
+func TestCmdPermission(t *testing.T) {
+	h := newTestHarness(t)
+	h.send("user", "/config get")
+	h.expectReaction(emojiNoEntry)
+	h.expectReply("only a server admin")
+
+	h.s.AddMember(h.cache.guildID, &discordgo.Member{User: &discordgo.User{ID: "admin"}}, discordgo.PermissionManageServer)
+	if err := h.send("admin", "/config get"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.expectReaction(emojiCheckMark)
+	h.expectReply("prefix")
+}
+
+func TestCmdDisable(t *testing.T) {
+	old := store
+	store = newTestStore(t)
+	defer func() { store = old }()
+	setTestConfig(t, func(c *Config) { c.Owners = []string{"100"} })
+
+	h := newTestHarness(t)
+	other := newTestHarness(t)
+	h.s.AddMember(h.cache.guildID, &discordgo.Member{User: &discordgo.User{ID: "disable-admin"}}, discordgo.PermissionManageServer)
+
+	if err := h.send("disable-admin", "/disable lookup Use it in the other server"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h.expectReply("Disabled **lookup** in this server")
+	h.send("disable-user", "/lookup rey")
+	h.expectReply("disabled in this server by the admins. Use it in the other server")
+	h.send("disable-user", "/help")
+	if strings.Contains(strings.Join(h.replies(), ""), "/lookup") {
+		t.Errorf("Unexpected disabled command in help: %v", h.replies())
+	}
+	other.send("disable-user", "/help")
+	other.expectReply("/lookup")
+
+	h.send("disable-admin", "/disable lookup +global")
+	h.expectReaction(emojiNoEntry)
+	h.send("disable-admin", "/disable config")
+	h.expectReply("can't be disabled")
+
+	if err := h.send("100", "/disable mods +global Back tomorrow"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	other.send("disable-user", "/mods rey")
+	other.expectReply("**mods** command was disabled. :cry: Back tomorrow")
+	other.send("disable-user", "/help mods")
+	other.expectReply("Back tomorrow")
+	h.send("disable-admin", "/disable")
+	h.expectReply("**mods** Back tomorrow")
+	h.expectReply(`lookup ("Use it in the other server")`)
+
+	h.send("100", "/enable mods +global")
+	h.expectReply("Enabled **mods** in all servers")
+	if _, ok := store.DisabledCommand("mods"); ok {
+		t.Errorf("Unexpected mods still disabled")
+	}
+	h.send("disable-admin", "/enable lookup")
+	h.expectReply("Enabled **lookup** in this server")
+	if h.cache.Settings().IsDisabled("lookup") {
+		t.Errorf("Unexpected lookup still disabled")
+	}
+}
+
+func TestCmdHandledError(t *testing.T) {
+	h := newTestHarness(t)
+	if err := h.send("handled-user", "/register 999-999-999"); err == nil {
+		t.Errorf("Expected error for an unknown player")
+	}
+	h.expectReaction(emojiCrossMark)
+	h.expectReply("could not find a player")
+	if len(h.s.Sent) != 1 {
+		t.Errorf("Unexpected replies: %q", h.replies())
+	}
+}
+
+func TestCmdRegisterLabel(t *testing.T) {
+	h := newTestHarness(t)
+	h.data.AddPlayer(&swgohhelp.Player{Name: "Player", AllyCode: 123456789})
+	for _, label := range []string{"ships", "more", "s", "global"} {
+		h.send("label-user", "/register 123-456-789 "+label)
+		h.expectReply("Please pick another account name")
+	}
+	if _, ok := h.cache.Link("label-user"); ok {
+		t.Errorf("Unexpected account linked with a flag name")
+	}
+	h.send("label-user", "/register 123-456-789 farm")
+	h.expectReply("as *farm*")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// configEnv are the environment variables that override the config file
+// values. They are also the default values of the flags.
+var configEnv = map[string]string{
+	"token":               "BOT_TOKEN",
+	"dev":                 "USE_DEV",
+	"username":            "API_USERNAME",
+	"password":            "API_PASSWORD",
+	"owners":              "BOT_OWNERS",
+	"incident-channel":    "BOT_INCIDENT_CHANNEL",
+	"cache-dir":           "BOT_CACHE_DIR",
+	"asset-dir":           "BOT_ASSET_DIR",
+	"shards":              "BOT_SHARDS",
+	"log-level":           "BOT_LOG_LEVEL",
+	"log-format":          "BOT_LOG_FORMAT",
+	"pagerender-host":     "PAGERENDER_PORT_8080_TCP_ADDR",
+	"pagerender-fallback": "PAGERENDER_PORT_8080_TCP_ADDR",
+	"interactions-addr":   "BOT_INTERACTIONS_ADDR",
+	"app-id":              "BOT_APP_ID",
+	"app-public-key":      "BOT_PUBLIC_KEY",
+	"status-addr":         "BOT_STATUS_ADDR",
+}
+
+// runtimeSettings are the config file settings applied on SIGHUP.
+// The others require a restart.
+var runtimeSettings = map[string]bool{
+	"owners":              true,
+	"incident-channel":    true,
+	"cmd-prefix":          true,
+	"cmd-timeout":         true,
+	"drain-timeout":       true,
+	"usage-retention":     true,
+	"log-level":           true,
+	"log-format":          true,
+	"pagerender-host":     true,
+	"pagerender-fallback": true,
+	"registry-channel":    true,
+	"embed-color":         true,
+}
+
+// Config holds the settings that can change while the bot runs.
+// Use conf() to read the current config.
+type Config struct {
+	Owners             []string
+	IncidentChannel    string
+	Prefix             string
+	CmdTimeout         time.Duration
+	UsageRetention     time.Duration
+	LogLevel           Level
+	LogFormat          string
+	PageRenderHost     string
+	PageRenderFallback bool
+	RegistryChannel    string
+	EmbedColor         int
+}
+
+var currentConfig = defaultConfig()
+
+// defaultConfig returns the config from the default flag values. It is not
+// validated, so invalid environment variables are reported by loadConfig.
+func defaultConfig() *atomic.Value {
+	c, _ := configFromFlags()
+	v := &atomic.Value{}
+	v.Store(c)
+	return v
+}
+
+// conf returns the current runtime config.
+func conf() *Config {
+	return currentConfig.Load().(*Config)
+}
+
+// setConfig replaces the current runtime config.
+func setConfig(c *Config) {
+	currentConfig.Store(c)
+	SetLogLevel(c.LogLevel)
+}
+
+var snowflakeRe = regexp.MustCompile("^[0-9]+$")
+
+// configFromFlags builds and validates the runtime config from the flag values.
+func configFromFlags() (*Config, configErrors) {
+	var errs configErrors
+	c := &Config{
+		Owners:             splitList(*owners),
+		IncidentChannel:    *incidentChannel,
+		Prefix:             *cmdPrefix,
+		CmdTimeout:         *cmdTimeout,
+		UsageRetention:     *usageRetention,
+		LogFormat:          *logFormat,
+		PageRenderHost:     *pageRenderHost,
+		PageRenderFallback: *pageRenderFallback,
+		RegistryChannel:    *registryChannel,
+	}
+	for _, id := range c.Owners {
+		if !snowflakeRe.MatchString(id) {
+			errs.add("owners", "%q is not a Discord user ID", id)
+		}
+	}
+	if c.IncidentChannel != "" && c.IncidentChannel != "dm" && !snowflakeRe.MatchString(c.IncidentChannel) {
+		errs.add("incident-channel", "must be a channel ID or dm")
+	}
+	if c.Prefix == "" || strings.ContainsAny(c.Prefix, " \t\n") {
+		errs.add("cmd-prefix", "must be set, without spaces")
+	}
+	if c.CmdTimeout <= 0 {
+		errs.add("cmd-timeout", "must be positive")
+	}
+	if *drainTimeout < 0 {
+		errs.add("drain-timeout", "can't be negative")
+	}
+	if c.UsageRetention < 24*time.Hour {
+		errs.add("usage-retention", "must be at least one day")
+	}
+	if c.LogLevel = LevelInfo; *logLevel != "" {
+		level, err := ParseLevel(*logLevel)
+		if err != nil {
+			errs.add("log-level", "must be debug, info, warn or error")
+		}
+		c.LogLevel = level
+	}
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		errs.add("log-format", "must be text or json")
+	}
+	if !strings.HasPrefix(c.PageRenderHost, "http://") && !strings.HasPrefix(c.PageRenderHost, "https://") {
+		errs.add("pagerender-host", "must be an http:// or https:// URL")
+	}
+	if c.RegistryChannel == "" {
+		errs.add("registry-channel", "must be set")
+	}
+	color, err := parseColor(*embedColorHex)
+	if err != nil {
+		errs.add("embed-color", "%v", err)
+	}
+	c.EmbedColor = color
+	return c, errs
+}
+
+// validateStartup checks the settings that are only used when the bot starts.
+func validateStartup() (errs configErrors) {
+	if *token == "" && !*console {
+		errs.add("token", "missing, set BOT_TOKEN")
+	}
+	if *shards < 0 {
+		errs.add("shards", "can't be negative")
+	}
+	found := false
+	for _, name := range dataSources {
+		found = found || name == *dataSource
+	}
+	if !found {
+		errs.add("data-source", "must be one of %s", strings.Join(dataSources, ", "))
+	}
+	if *interactionsAddr != "" {
+		if key, err := hex.DecodeString(*appPublicKey); err != nil || len(key) != 32 {
+			errs.add("app-public-key", "must be the hex encoded application public key to serve interactions")
+		}
+	}
+	return errs
+}
+
+// configErrors collects the invalid settings.
+type configErrors []string
+
+func (e *configErrors) add(name, format string, args ...interface{}) {
+	*e = append(*e, name+": "+fmt.Sprintf(format, args...))
+}
+
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(e, "\n  "))
+}
+
+// readConfigFile reads the JSON config file, a single object with the flag
+// names as keys. Values can be strings, numbers, booleans or lists of strings.
+func readConfigFile(fs *flag.FlagSet, file string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	values := make(map[string]string)
+	var errs configErrors
+	for name, v := range raw {
+		if fs.Lookup(name) == nil || name == "config" {
+			errs.add(name, "unknown setting")
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case bool, json.Number:
+			values[name] = fmt.Sprint(v)
+		case []interface{}:
+			var list []string
+			for _, item := range v {
+				list = append(list, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(list, ",")
+		default:
+			errs.add(name, "must be a string, number, boolean or list")
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return values, nil
+}
+
+// configFile applies the config file values to the flags. Flags given in the
+// command line (set) and settings from the environment are not changed.
+// If reload is true, only the runtimeSettings are changed, and the settings
+// missing from the file return to their defaults. It returns the names of
+// the settings that need a restart to change.
+func configFile(fs *flag.FlagSet, file string, set map[string]bool, reload bool) (restart []string, err error) {
+	values, err := readConfigFile(fs, file)
+	if err != nil {
+		return nil, err
+	}
+	var errs configErrors
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || os.Getenv(configEnv[f.Name]) != "" {
+			return
+		}
+		v, ok := values[f.Name]
+		if reload && !runtimeSettings[f.Name] {
+			if ok && v != f.Value.String() {
+				restart = append(restart, f.Name)
+			}
+			return
+		}
+		if !ok {
+			if !reload {
+				return
+			}
+			v = f.DefValue
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs.add(f.Name, "%v", err)
+		}
+	})
+	if err := errs.err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	sort.Strings(restart)
+	return restart, nil
+}
+
+// commandLineFlags returns the names of the flags set in the command line.
+func commandLineFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// loadConfig applies the -config file and validates the settings.
+func loadConfig() error {
+	if *configPath != "" {
+		if _, err := configFile(flag.CommandLine, *configPath, commandLineFlags(), false); err != nil {
+			return err
+		}
+	}
+	c, errs := configFromFlags()
+	errs = append(errs, validateStartup()...)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	setConfig(c)
+	return nil
+}
+
+// reloadConfig applies the runtime settings of the -config file again.
+// Invalid files are logged, and the current flags and config are kept.
+func reloadConfig(set map[string]bool) {
+	if *configPath == "" {
+		logger.Warnf("No config file to reload, use -config")
+		return
+	}
+	saved := flagValues(flag.CommandLine)
+	restart, err := configFile(flag.CommandLine, *configPath, set, true)
+	var c *Config
+	if err == nil {
+		var errs configErrors
+		c, errs = configFromFlags()
+		err = errs.err()
+	}
+	if err != nil {
+		restoreFlags(flag.CommandLine, saved)
+		logger.Errorf("Unable to reload the config: %v", err)
+		return
+	}
+	setConfig(c)
+	logger.Infof("Config reloaded from %v", *configPath)
+	if len(restart) > 0 {
+		logger.Warnf("Restart to apply the changes to %s", strings.Join(restart, ", "))
+	}
+}
+
+// flagValues returns the current values of all flags.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// restoreFlags sets the flags back to the values saved with flagValues.
+func restoreFlags(fs *flag.FlagSet, values map[string]string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := values[f.Name]; ok && v != f.Value.String() {
+			fs.Set(f.Name, v)
+		}
+	})
+}
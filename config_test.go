@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestConfig changes the runtime config until the test ends.
+func setTestConfig(t *testing.T, fn func(c *Config)) {
+	old := conf()
+	c := *old
+	fn(&c)
+	setConfig(&c)
+	t.Cleanup(func() { setConfig(old) })
+}
+
+// writeConfig writes the config file in a temporary directory.
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "ap-5r.json")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+	return file
+}
+
+func TestConfigFile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	owners := fs.String("owners", "", "")
+	prefix := fs.String("cmd-prefix", "/", "")
+	timeout := fs.Duration("cmd-timeout", time.Minute, "")
+	shards := fs.Int("shards", 0, "")
+	token := fs.String("token", "", "")
+	fs.Parse([]string{"-cmd-prefix", "!"})
+	set := map[string]bool{"cmd-prefix": true}
+	t.Setenv("BOT_TOKEN", "from-env")
+
+	file := writeConfig(t, `{
+		"owners": ["123456789012345678", "2"],
+		"cmd-prefix": "?",
+		"cmd-timeout": "30s",
+		"shards": 3,
+		"token": "from-file"
+	}`)
+	if _, err := configFile(fs, file, set, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *owners != "123456789012345678,2" || *timeout != 30*time.Second || *shards != 3 {
+		t.Errorf("Unexpected values from the file: %v %v %v", *owners, *timeout, *shards)
+	}
+	if *prefix != "!" || *token != "" {
+		t.Errorf("Unexpected flag and env overrides: prefix=%v token=%v", *prefix, *token)
+	}
+
+	// Reload only changes the runtime settings, and resets the missing ones
+	file = writeConfig(t, `{"shards": 5, "cmd-timeout": "10s"}`)
+	restart, err := configFile(fs, file, set, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *shards != 3 || *timeout != 10*time.Second || *owners != "" {
+		t.Errorf("Unexpected values after reload: shards=%v timeout=%v owners=%v", *shards, *timeout, *owners)
+	}
+	if strings.Join(restart, ",") != "shards" {
+		t.Errorf("Unexpected settings needing restart: %v", restart)
+	}
+
+	for _, content := range []string{`{"cmd-timeout": "soon"}`, `{"unknown": 1}`, `{"owners": {}}`, `not json`} {
+		if _, err := configFile(fs, writeConfig(t, content), set, false); err == nil {
+			t.Errorf("Expected error for config %s", content)
+		}
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	old := *cmdTimeout
+	defer func() { *cmdTimeout = old }()
+	*cmdTimeout = 0
+	_, errs := configFromFlags()
+	if err := errs.err(); err == nil || !strings.Contains(err.Error(), "cmd-timeout: must be positive") {
+		t.Errorf("Unexpected validation error: %v", err)
+	}
+}
+
+func TestConfigReloadInvalid(t *testing.T) {
+	oldPath, oldTimeout, oldPrefix := *configPath, *cmdTimeout, *cmdPrefix
+	defer func() { *configPath, *cmdTimeout, *cmdPrefix = oldPath, oldTimeout, oldPrefix }()
+	before := conf()
+
+	*configPath = writeConfig(t, `{"cmd-prefix": "!", "cmd-timeout": "0s"}`)
+	reloadConfig(nil)
+	if *cmdTimeout != oldTimeout || *cmdPrefix != oldPrefix {
+		t.Errorf("Unexpected flags changed by an invalid reload: cmd-timeout=%v cmd-prefix=%v", *cmdTimeout, *cmdPrefix)
+	}
+	if conf() != before {
+		t.Errorf("Unexpected config changed by an invalid reload: %#v", conf())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// consoleChannel is the channel ID of the console mode messages.
+const consoleChannel = "console"
+
+// unsafeFileChars are replaced when saving attachments.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._ ()-]+`)
+
+// runConsole reads command lines from in and runs them with the dispatcher
+// as the console user, in a fake server. Replies are printed to out, and
+// images are saved in the -console-out directory.
+// The console user is a bot owner, so scripts are not rate limited.
+// Lines without the command prefix are run as commands too, and lines
+// starting with # are ignored, so scripts can be piped in.
+func runConsole(in io.Reader, out io.Writer) error {
+	if *consoleData != "" {
+		data, err := LoadMemoryDataSource(*consoleData)
+		if err != nil {
+			return err
+		}
+		dispatcher.data = data
+	}
+	if err := os.MkdirAll(*consoleOut, 0755); err != nil {
+		return err
+	}
+
+	c := *conf()
+	c.Owners = append(append([]string{}, c.Owners...), *consoleUser)
+	setConfig(&c)
+
+	s := NewFakeSession()
+	guild := &discordgo.Guild{ID: "console", Name: *consoleGuild}
+	s.AddGuild(guild,
+		&discordgo.Channel{ID: consoleChannel, Name: "console"},
+		&discordgo.Channel{ID: "console-registry", Name: conf().RegistryChannel})
+	user := &discordgo.User{ID: *consoleUser, Username: *consoleUser}
+	s.AddMember(guild.ID, &discordgo.Member{User: user}, discordgo.PermissionAdministrator)
+	// n is the input line number, also the ID of the command message
+	var n int
+	s.OnSend = func(m *FakeMessage) {
+		printConsoleMessage(out, n, m)
+	}
+
+	cache, _ := guildCacheFor(guild.ID, guild.Name)
+	for _, link := range splitList(*consoleAllyCodes) {
+		userID, allyCode := *consoleUser, link
+		if i := strings.Index(link, "="); i >= 0 {
+			userID, allyCode = link[:i], link[i+1:]
+		}
+		cache.SetLink(&ProfileLink{UserID: userID, AllyCode: nonDigits.ReplaceAllString(allyCode, "")})
+	}
+
+	prefix := cache.Settings().WithDefaults().Prefix
+	scanner := bufio.NewScanner(in)
+	for n = 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, prefix) {
+			line = prefix + line
+		}
+		m := &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        strconv.Itoa(n),
+			ChannelID: consoleChannel,
+			Content:   line,
+			Author:    user,
+		}}
+		if err := dispatcher.Dispatch(s, m); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+		for _, r := range s.Reactions {
+			fmt.Fprintf(out, "[%s]\n", r.Emoji)
+		}
+		s.Reset()
+	}
+	return scanner.Err()
+}
+
+// printConsoleMessage prints the message text and embed, and saves the attachments
+// prefixed with the input line number, so commands don't overwrite each other's images.
+func printConsoleMessage(out io.Writer, n int, m *FakeMessage) {
+	if m.Content != "" {
+		fmt.Fprintln(out, m.Content)
+	}
+	if e := m.Embed; e != nil {
+		fmt.Fprintf(out, "| %s %s\n", e.Title, e.URL)
+		if e.Description != "" {
+			fmt.Fprintf(out, "| %s\n", strings.Replace(e.Description, "\n", "\n| ", -1))
+		}
+		for _, f := range e.Fields {
+			fmt.Fprintf(out, "| %s: %s\n", f.Name, strings.Replace(f.Value, "\n", "\n|   ", -1))
+		}
+	}
+	for _, f := range m.Files {
+		name := fmt.Sprintf("%d-%s", n, f.Name)
+		file := filepath.Join(*consoleOut, unsafeFileChars.ReplaceAllString(name, "_"))
+		if err := ioutil.WriteFile(file, f.Data, 0644); err != nil {
+			fmt.Fprintf(out, "error: unable to save %s: %v\n", f.Name, err)
+			continue
+		}
+		fmt.Fprintf(out, "(saved %s)\n", file)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunConsole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ap5r-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+	err = ioutil.WriteFile(fixture, []byte(`{"players": {"123456789": {"name": "Player", "allyCode": 123456789,
+		"roster": [{"nameKey": "Darth Vader", "rarity": 7, "gear": 12, "level": 85, "stats": {"final": {"Speed": 180}}}]}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(data, out, allyCodes string) {
+		*consoleData, *consoleOut, *consoleAllyCodes = data, out, allyCodes
+	}(*consoleData, *consoleOut, *consoleAllyCodes)
+	*consoleData, *consoleOut, *consoleAllyCodes = fixture, filepath.Join(dir, "images"), "123-456-789"
+
+	setTestConfig(t, func(c *Config) {})
+
+	var out bytes.Buffer
+	in := strings.NewReader("# comments are skipped\n/stats darth vader\nstats darth vader\nstats rey\n/not-a-command\n" +
+		strings.Repeat("help stats\n", 15))
+	if err := runConsole(in, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{"Wow, nice stats", "(saved " + filepath.Join(dir, "images", "2-Player - Darth Vader.png") + ")",
+		"Darth Vader", "not activated", "[" + emojiQuestionMark + "]"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the output:\n%s", expected, out.String())
+		}
+	}
+	for _, name := range []string{"2-Player - Darth Vader.png", "3-Player - Darth Vader.png"} {
+		if _, err := os.Stat(filepath.Join(dir, "images", name)); err != nil {
+			t.Errorf("Image not saved: %v", err)
+		}
+	}
+	if strings.Contains(out.String(), "Easy there") {
+		t.Errorf("Unexpected rate limit in the output:\n%s", out.String())
+	}
+}
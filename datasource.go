@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ronoaldo/swgoh/swgohgg"
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+var (
+	errUnsupported    = errors.New("ap-5r: not supported by the data source")
+	errNotFound       = errors.New("ap-5r: not found")
+	errProfileLoading = errors.New("ap-5r: profile is still loading")
+)
+
+// GameData are the game definitions for units and factions.
+type GameData struct {
+	Units      map[string]swgohhelp.DataUnit         `json:"units"`
+	Categories map[string]swgohhelp.DataUnitCategory `json:"categories"`
+}
+
+// PlayerDataSource loads the player and game data used by the commands.
+// Methods the backend can't provide return errUnsupported.
+type PlayerDataSource interface {
+	// Player loads the player profile and roster.
+	Player(ctx context.Context, allyCode string) (*swgohhelp.Player, error)
+	// Guild loads the guild of the player.
+	Guild(ctx context.Context, allyCode string) (*swgohhelp.Guild, error)
+	// GameData loads the game definitions.
+	GameData(ctx context.Context) (*GameData, error)
+	// AllyCode looks up the ally code of a swgoh.gg profile username.
+	AllyCode(ctx context.Context, username string) (string, error)
+}
+
+// dataSources is the list of available PlayerDataSource backends, see -data-source.
+var dataSources = []string{"swgohhelp", "swgoh.gg", "appspot"}
+
+// NewDataSource returns the backend with the given name, falling back to the
+// other backends for the data it can't provide.
+func NewDataSource(name string, api *APIClient) (PlayerDataSource, error) {
+	all := map[string]PlayerDataSource{
+		"swgohhelp": &helpDataSource{api: api},
+		"swgoh.gg":  ggDataSource{},
+		"appspot":   appspotDataSource{},
+	}
+	primary, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown data source %s, use one of %v", name, dataSources)
+	}
+	sources := fallbackDataSource{primary}
+	for _, n := range dataSources {
+		if n != name {
+			sources = append(sources, all[n])
+		}
+	}
+	return sources, nil
+}
+
+// runWithContext calls fn in a goroutine, returning early when ctx is done.
+// The vendored API clients don't support cancellation, so fn keeps running
+// in the background until it finishes.
+func runWithContext(ctx context.Context, fn func() error) error {
+	c := make(chan error, 1)
+	go func() {
+		c <- fn()
+	}()
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fallbackDataSource tries each data source in order, until one of them
+// supports the call.
+type fallbackDataSource []PlayerDataSource
+
+func (f fallbackDataSource) Player(ctx context.Context, allyCode string) (player *swgohhelp.Player, err error) {
+	for _, src := range f {
+		if player, err = src.Player(ctx, allyCode); err != errUnsupported {
+			return player, err
+		}
+	}
+	return nil, errUnsupported
+}
+
+func (f fallbackDataSource) Guild(ctx context.Context, allyCode string) (guild *swgohhelp.Guild, err error) {
+	for _, src := range f {
+		if guild, err = src.Guild(ctx, allyCode); err != errUnsupported {
+			return guild, err
+		}
+	}
+	return nil, errUnsupported
+}
+
+func (f fallbackDataSource) GameData(ctx context.Context) (data *GameData, err error) {
+	for _, src := range f {
+		if data, err = src.GameData(ctx); err != errUnsupported {
+			return data, err
+		}
+	}
+	return nil, errUnsupported
+}
+
+func (f fallbackDataSource) AllyCode(ctx context.Context, username string) (allyCode string, err error) {
+	for _, src := range f {
+		if allyCode, err = src.AllyCode(ctx, username); err != errUnsupported {
+			return allyCode, err
+		}
+	}
+	return "", errUnsupported
+}
+
+// helpDataSource loads data from api.swgoh.help.
+type helpDataSource struct {
+	api *APIClient
+}
+
+func (h *helpDataSource) Player(ctx context.Context, allyCode string) (*swgohhelp.Player, error) {
+	return h.api.Player(ctx, allyCode)
+}
+
+func (h *helpDataSource) Guild(ctx context.Context, allyCode string) (guild *swgohhelp.Guild, err error) {
+	err = h.api.Do(ctx, func(api *swgohhelp.Client) (err error) {
+		guild, err = api.Guild(allyCode)
+		return err
+	})
+	return guild, err
+}
+
+func (h *helpDataSource) GameData(ctx context.Context) (data *GameData, err error) {
+	data = &GameData{}
+	err = h.api.Do(ctx, func(api *swgohhelp.Client) (err error) {
+		if data.Categories, err = api.DataUnitCategories(); err != nil {
+			return err
+		}
+		data.Units, err = api.DataUnits()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (h *helpDataSource) AllyCode(ctx context.Context, username string) (string, error) {
+	return "", errUnsupported
+}
+
+// ggDataSource scrapes the swgoh.gg website. Only the basic unit
+// stats (stars, level and gear) are available.
+type ggDataSource struct{}
+
+func (ggDataSource) Player(ctx context.Context, allyCode string) (player *swgohhelp.Player, err error) {
+	err = runWithContext(ctx, func() error {
+		gg := swgohgg.NewClient("").SetAllyCode(allyCode)
+		collection, err := gg.Collection()
+		if err != nil {
+			return err
+		}
+		ships, err := gg.Ships()
+		if err != nil {
+			return err
+		}
+		player = profilePlayer(allyCode, collection, ships)
+		player.Name, player.GuildName = gg.PlayerName(), gg.GuildName()
+		return nil
+	})
+	return player, err
+}
+
+func (ggDataSource) Guild(ctx context.Context, allyCode string) (*swgohhelp.Guild, error) {
+	return nil, errUnsupported
+}
+
+func (ggDataSource) GameData(ctx context.Context) (*GameData, error) {
+	return nil, errUnsupported
+}
+
+func (ggDataSource) AllyCode(ctx context.Context, username string) (allyCode string, err error) {
+	err = runWithContext(ctx, func() error {
+		allyCode = swgohgg.NewClient(username).AllyCode()
+		return nil
+	})
+	if err == nil && allyCode == "" {
+		err = errNotFound
+	}
+	return allyCode, err
+}
+
+// appspotDataSource loads the swgoh.gg profiles cached by the
+// https://swgoh-api.appspot.com/ proxy.
+type appspotDataSource struct{}
+
+func (appspotDataSource) Player(ctx context.Context, allyCode string) (*swgohhelp.Player, error) {
+	profile, err := GetProfile(ctx, allyCode)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, errProfileLoading
+	}
+	player := profilePlayer(allyCode, profile.Collection, profile.Ships)
+	player.UpdatedAt = swgohhelp.Timestamp(profile.LastUpdate)
+	return player, nil
+}
+
+func (appspotDataSource) Guild(ctx context.Context, allyCode string) (*swgohhelp.Guild, error) {
+	return nil, errUnsupported
+}
+
+func (appspotDataSource) GameData(ctx context.Context) (*GameData, error) {
+	return nil, errUnsupported
+}
+
+func (appspotDataSource) AllyCode(ctx context.Context, username string) (string, error) {
+	return "", errUnsupported
+}
+
+// profilePlayer converts a swgoh.gg collection into a player roster.
+func profilePlayer(allyCode string, collection swgohgg.Collection, ships swgohgg.Ships) *swgohhelp.Player {
+	player := &swgohhelp.Player{}
+	player.AllyCode, _ = strconv.Atoi(allyCode)
+	for _, c := range collection {
+		player.Roster = append(player.Roster, swgohhelp.Unit{
+			Name:       c.Name,
+			Rarity:     c.Stars,
+			Level:      c.Level,
+			Gear:       c.Gear,
+			CombatType: swgohhelp.CombatTypeChar,
+		})
+	}
+	for _, s := range ships {
+		player.Roster = append(player.Roster, swgohhelp.Unit{
+			Name:       s.Name,
+			Rarity:     s.Stars,
+			Level:      s.Level,
+			CombatType: swgohhelp.CombatTypeShip,
+		})
+	}
+	return player
+}
+
+// MemoryDataSource serves players, guilds and game data from memory,
+// usually loaded from a fixture file with LoadMemoryDataSource.
+// It is used to run the commands without network access.
+type MemoryDataSource struct {
+	mu        sync.Mutex
+	Players   map[string]*swgohhelp.Player `json:"players"`
+	Guilds    map[string]*swgohhelp.Guild  `json:"guilds"`
+	Data      *GameData                    `json:"gameData"`
+	AllyCodes map[string]string            `json:"allyCodes"`
+	// Delay is added to all calls, to simulate slow backends.
+	Delay time.Duration `json:"-"`
+}
+
+// NewMemoryDataSource returns an empty MemoryDataSource.
+func NewMemoryDataSource() *MemoryDataSource {
+	return &MemoryDataSource{
+		Players:   make(map[string]*swgohhelp.Player),
+		Guilds:    make(map[string]*swgohhelp.Guild),
+		Data:      &GameData{},
+		AllyCodes: make(map[string]string),
+	}
+}
+
+// LoadMemoryDataSource loads a MemoryDataSource from a JSON fixture file, with
+// the players and guilds keyed by ally code and the ally codes keyed by username.
+func LoadMemoryDataSource(file string) (*MemoryDataSource, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMemoryDataSource()
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %v", file, err)
+	}
+	return m, nil
+}
+
+// AddPlayer adds the player, keyed by its ally code.
+func (m *MemoryDataSource) AddPlayer(player *swgohhelp.Player) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Players[strconv.Itoa(player.AllyCode)] = player
+}
+
+// wait sleeps for the configured delay, or until ctx is done.
+func (m *MemoryDataSource) wait(ctx context.Context) error {
+	select {
+	case <-time.After(m.Delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MemoryDataSource) Player(ctx context.Context, allyCode string) (*swgohhelp.Player, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	player, ok := m.Players[nonDigits.ReplaceAllString(allyCode, "")]
+	if !ok {
+		return nil, fmt.Errorf("player %s not found", allyCode)
+	}
+	return player, nil
+}
+
+func (m *MemoryDataSource) Guild(ctx context.Context, allyCode string) (*swgohhelp.Guild, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	guild, ok := m.Guilds[nonDigits.ReplaceAllString(allyCode, "")]
+	if !ok {
+		return nil, errNotFound
+	}
+	return guild, nil
+}
+
+func (m *MemoryDataSource) GameData(ctx context.Context) (*GameData, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Data, nil
+}
+
+func (m *MemoryDataSource) AllyCode(ctx context.Context, username string) (string, error) {
+	if err := m.wait(ctx); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	allyCode, ok := m.AllyCodes[username]
+	if !ok {
+		return "", errNotFound
+	}
+	return allyCode, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+func TestMemoryDataSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ap5r-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+	err = ioutil.WriteFile(fixture, []byte(`{
+		"players": {"123456789": {"name": "Player", "allyCode": 123456789, "roster": [{"nameKey": "Rey (Jedi Training)", "rarity": 7}]}},
+		"allyCodes": {"player": "123456789"}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadMemoryDataSource(fixture)
+	if err != nil {
+		t.Fatalf("Unable to load fixture: %v", err)
+	}
+	m.AddPlayer(&swgohhelp.Player{Name: "Other", AllyCode: 987654321})
+
+	ctx := context.Background()
+	allyCode, err := m.AllyCode(ctx, "player")
+	if err != nil || allyCode != "123456789" {
+		t.Errorf("Unexpected ally code: %v (err=%v)", allyCode, err)
+	}
+	player, err := m.Player(ctx, "123-456-789")
+	if err != nil {
+		t.Fatalf("Unexpected error loading player: %v", err)
+	}
+	if _, ok := player.Roster.FindByName("Rey (Jedi Training)"); !ok || player.Name != "Player" {
+		t.Errorf("Unexpected player: %#v", player)
+	}
+	if player, err := m.Player(ctx, "987654321"); err != nil || player.Name != "Other" {
+		t.Errorf("Unexpected added player: %v (err=%v)", player, err)
+	}
+	if _, err := m.Player(ctx, "111111111"); err == nil {
+		t.Errorf("Expected error loading unknown player")
+	}
+}
+
+func TestFallbackDataSource(t *testing.T) {
+	m := NewMemoryDataSource()
+	m.AllyCodes["player"] = "123456789"
+	m.AddPlayer(&swgohhelp.Player{Name: "Player", AllyCode: 123456789})
+	data := fallbackDataSource{appspotDataSource{}, m}
+
+	ctx := context.Background()
+	// appspot does not support ally code lookups, so the memory source is used
+	if allyCode, err := data.AllyCode(ctx, "player"); err != nil || allyCode != "123456789" {
+		t.Errorf("Unexpected ally code: %v (err=%v)", allyCode, err)
+	}
+	if _, err := (fallbackDataSource{appspotDataSource{}}).GameData(ctx); err != errUnsupported {
+		t.Errorf("Unexpected error for unsupported call: %v", err)
+	}
+	if _, err := NewDataSource("unknown", nil); err == nil {
+		t.Errorf("Expected error for unknown data source")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DisabledCommand is a command disabled by the bot owners in all servers.
+type DisabledCommand struct {
+	Command string    `json:"command"`
+	Message string    `json:"message,omitempty"`
+	By      string    `json:"by"`
+	Time    time.Time `json:"time"`
+}
+
+// alwaysEnabled are the commands that can't be disabled, so the others
+// can be enabled again.
+var alwaysEnabled = map[string]bool{"enable": true, "disable": true, "config": true}
+
+// commandDisabled returns the reply for a command disabled in all servers
+// or in this one, and true if it is disabled. The command can be disabled
+// by its name or, in settings saved before /config resolved aliases, by the
+// alias used.
+func commandDisabled(settings GuildSettings, cmd *Command, alias string) (string, bool) {
+	if alwaysEnabled[cmd.Name] {
+		return "", false
+	}
+	if d, ok := store.DisabledCommand(cmd.Name); ok {
+		msg := fmt.Sprintf("Oh no! I am so sorry but the **%s** command was disabled. :cry:", alias)
+		if d.Message != "" {
+			msg += " " + d.Message
+		}
+		return msg, true
+	}
+	for _, name := range []string{cmd.Name, alias} {
+		if m, ok := settings.DisabledMessage(name); ok {
+			msg := fmt.Sprintf("Sorry, the **%s** command was disabled in this server by the admins.", alias)
+			if m != "" {
+				msg += " " + m
+			}
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// cmdDisable disables a command in this server, or in all servers with +global.
+// Without a command, it lists the disabled commands.
+func cmdDisable(r CmdRequest) (err error) {
+	fields := strings.Fields(r.args.Name)
+	if len(fields) == 0 {
+		return listDisabled(r)
+	}
+	cmd, ok := toggledCommand(r, fields[0])
+	if !ok {
+		return nil
+	}
+	message := strings.Join(fields[1:], " ")
+	if r.args.ContainsFlag("+global") {
+		if !isOwner(r.m.Author.ID) {
+			send(r.s, r.m.ChannelID, "Sorry %s, only %s can disable commands in all servers.", r.m.Author.Mention(), PermOwner)
+			return errPermissionDenied
+		}
+		d := &DisabledCommand{Command: cmd.Name, Message: message, By: r.m.Author.String(), Time: time.Now()}
+		if err = store.PutDisabledCommand(d); err != nil {
+			send(r.s, r.m.ChannelID, "Oh no! I was unable to disable **%s** :(", cmd.Name)
+			return err
+		}
+		logger.Warnf("Command %v disabled in all servers by %v", cmd.Name, r.m.Author)
+		_, err = send(r.s, r.m.ChannelID, "Disabled **%s** in all servers. Use %senable %s +global to enable it again.",
+			cmd.Name, r.settings.Prefix, cmd.Name)
+		return err
+	}
+	settings := r.cache.Settings()
+	settings.Disable(cmd.Name, message)
+	if err = r.cache.SetSettings(settings); err != nil {
+		send(r.s, r.m.ChannelID, "Oh no! I was unable to save the settings :(")
+		return err
+	}
+	r.l.Printf("Command %v disabled by %v", cmd.Name, r.m.Author)
+	_, err = send(r.s, r.m.ChannelID, "Disabled **%s** in this server. Use %senable %s to enable it again.",
+		cmd.Name, r.settings.Prefix, cmd.Name)
+	return err
+}
+
+// cmdEnable enables a command disabled in this server, or in all servers with +global.
+func cmdEnable(r CmdRequest) (err error) {
+	fields := strings.Fields(r.args.Name)
+	if len(fields) == 0 {
+		send(r.s, r.m.ChannelID, "%s, tell me the command to enable. Try this: %senable lookup", r.m.Author.Mention(), r.settings.Prefix)
+		return nil
+	}
+	cmd, ok := toggledCommand(r, fields[0])
+	if !ok {
+		return nil
+	}
+	if r.args.ContainsFlag("+global") {
+		if !isOwner(r.m.Author.ID) {
+			send(r.s, r.m.ChannelID, "Sorry %s, only %s can enable commands in all servers.", r.m.Author.Mention(), PermOwner)
+			return errPermissionDenied
+		}
+		if _, ok := store.DisabledCommand(cmd.Name); !ok {
+			_, err = send(r.s, r.m.ChannelID, "**%s** is not disabled in all servers.", cmd.Name)
+			return err
+		}
+		if err = store.DeleteDisabledCommand(cmd.Name); err != nil {
+			send(r.s, r.m.ChannelID, "Oh no! I was unable to enable **%s** :(", cmd.Name)
+			return err
+		}
+		logger.Warnf("Command %v enabled in all servers by %v", cmd.Name, r.m.Author)
+		_, err = send(r.s, r.m.ChannelID, "Enabled **%s** in all servers.", cmd.Name)
+		return err
+	}
+	settings := r.cache.Settings()
+	if !settings.IsDisabled(cmd.Name) {
+		_, err = send(r.s, r.m.ChannelID, "**%s** is not disabled in this server.", cmd.Name)
+		return err
+	}
+	settings.Enable(cmd.Name)
+	if err = r.cache.SetSettings(settings); err != nil {
+		send(r.s, r.m.ChannelID, "Oh no! I was unable to save the settings :(")
+		return err
+	}
+	r.l.Printf("Command %v enabled by %v", cmd.Name, r.m.Author)
+	_, err = send(r.s, r.m.ChannelID, "Enabled **%s** in this server.", cmd.Name)
+	return err
+}
+
+// toggledCommand returns the command named by the user, replying if it
+// is unknown or can't be disabled.
+func toggledCommand(r CmdRequest, name string) (*Command, bool) {
+	name = strings.TrimPrefix(strings.ToLower(name), r.settings.Prefix)
+	cmd, ok := dispatcher.Command(name)
+	if !ok {
+		send(r.s, r.m.ChannelID, "Sorry %s, I don't know the command **%s**. Try %shelp.",
+			r.m.Author.Mention(), name, r.settings.Prefix)
+		return nil, false
+	}
+	if alwaysEnabled[cmd.Name] {
+		send(r.s, r.m.ChannelID, "Sorry %s, the **%s** command can't be disabled.", r.m.Author.Mention(), cmd.Name)
+		return nil, false
+	}
+	return cmd, true
+}
+
+// listDisabled displays the commands disabled in all servers and in this one.
+func listDisabled(r CmdRequest) (err error) {
+	global, err := store.DisabledCommands()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range global {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "Disabled in all servers:\n")
+	for _, name := range names {
+		fmt.Fprintf(&m, "**%s** %s\n", name, global[name].Message)
+	}
+	if len(names) == 0 {
+		fmt.Fprintf(&m, "(none)\n")
+	}
+	here, _ := r.cache.Settings().Get("disabled-commands")
+	fmt.Fprintf(&m, "\nDisabled in **%s**: %s\n", r.guild.Name, here)
+	_, err = send(r.s, r.m.ChannelID, "%s", m.String())
+	return err
+}
@@ -7,8 +7,8 @@ import (
 	"image/color"
 	"image/draw"
 	"math"
-	"os"
 	"strconv"
+	"strings"
 
 	"github.com/golang/freetype/truetype"
 	"github.com/ronoaldo/swgoh/swgohhelp"
@@ -54,6 +54,8 @@ func init() {
 
 type drawer struct {
 	player swgohhelp.Player
+	// l is the request logger. The main logger is used if nil.
+	l *Logger
 
 	bold bool
 	size float64
@@ -72,6 +74,9 @@ type drawer struct {
 
 // DrawCharacterStats draws character unit stats with a beautiful image
 func (d *drawer) DrawCharacterStats(u *swgohhelp.Unit) ([]byte, error) {
+	if u.Stats == nil {
+		return nil, fmt.Errorf("draw: no stats for %s", u.Name)
+	}
 	// Load drawing assets
 	bg, err := loadAsset("ui/ap-5r-char-stats_background.png")
 	if err != nil {
@@ -95,7 +100,7 @@ func (d *drawer) DrawCharacterStats(u *swgohhelp.Unit) ([]byte, error) {
 	}
 	char, err := loadAsset(fmt.Sprintf("characters/%s.png", u.Name))
 	if err != nil {
-		logger.Errorf("Error loading character characters/%s.png", u.Name)
+		d.logger().Errorf("Error loading character characters/%s.png", u.Name)
 	}
 
 	// Prepare unit canvas
@@ -233,7 +238,8 @@ func (d *drawer) DrawCharacterStats(u *swgohhelp.Unit) ([]byte, error) {
 }
 
 // DrawUnitList draw a unit list, 5 per row.
-// Usefull for drawing a team or a roster of units by category
+// Usefull for drawing a team or a roster of units by category.
+// Units with zero rarity are not unlocked by the player and are greyed out.
 func (d *drawer) DrawUnitList(units []swgohhelp.Unit) ([]byte, error) {
 	// 5 per row, 100px per unit, 10px padding
 	padding := 30
@@ -252,50 +258,234 @@ func (d *drawer) DrawUnitList(units []swgohhelp.Unit) ([]byte, error) {
 	}
 
 	// draw each unit portrait
-	x, y := padding, padding
 	for unitCount, u := range units {
-		// Draw portrait
-		portrait, err := loadAsset(fmt.Sprintf("characters/%s_portrait.png", u.Name))
-		if err != nil {
-			logger.Errorf("Error loading character image portrait %v: %v", u.Name, err)
+		x := padding + (unitCount%5)*unitSize
+		y := padding + (unitCount/5)*unitSize
+		d.drawUnitPortrait(canvas, bundle, &u, x, y)
+	}
+
+	var b bytes.Buffer
+	if err := canvas.EncodePNG(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DrawArenaSquad draws the arena squad in a single row, with the
+// squad rank and the unit roles, like the leader, bellow each portrait.
+func (d *drawer) DrawArenaSquad(rank int, units []swgohhelp.Unit, roles []swgohhelp.SquadUnitType) ([]byte, error) {
+	padding := 30
+	portraitSize := 100
+	unitSize := portraitSize + padding*2
+	header := 60
+	width := unitSize * int(math.Max(5, float64(len(units))))
+	height := header + unitSize + 30
+
+	canvas := gg.NewContext(width, height)
+	canvas.SetHexColor("#0D1D25")
+	canvas.Clear()
+
+	d.size, d.bold = 30, true
+	d.x, d.y = f(width/2), 35
+	d.textCenter()
+	d.printf(canvas, "%s - Rank #%d", d.player.Name, rank)
+
+	bundle := &assetBundle{
+		ui: make(map[string]image.Image),
+	}
+	// Center the squad when there are less than 5 units
+	offset := (width - unitSize*len(units)) / 2
+	for i, u := range units {
+		x := offset + padding + i*unitSize
+		y := header + padding
+		d.drawUnitPortrait(canvas, bundle, &u, x, y)
+
+		role := roles[i].String()
+		if role == "" {
 			continue
 		}
-		croppedPortrait := cropCircle(portrait)
-		canvas.DrawImage(croppedPortrait, x, y)
+		d.size, d.bold = 20, true
+		d.color = "#ffffff"
+		if roles[i] == swgohhelp.SquadUnitLeader {
+			d.color = "#ffd036"
+		}
+		d.x, d.y = f(x+portraitSize/2), f(y+portraitSize+25)
+		d.textCenter()
+		d.printf(canvas, "%s", role)
+		d.color = "#ffffff"
+	}
 
-		// Draw gear
-		gear, _ := bundle.loadUIAsset(fmt.Sprintf("ui/gear-icon-g%d_100x100.png", u.Gear))
-		if gear != nil {
-			canvas.DrawImage(gear, x, y)
+	var b bytes.Buffer
+	if err := canvas.EncodePNG(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// drawUnitPortrait draws the unit portrait at x, y, with gear, stars
+// and the relic and zeta badges. Locked units are greyed out.
+func (d *drawer) drawUnitPortrait(canvas *gg.Context, bundle *assetBundle, u *swgohhelp.Unit, x, y int) {
+	portraitSize := 100
+	unitSize := 160
+	locked := u.Rarity == 0
+
+	// Draw portrait
+	portrait, err := loadAsset(fmt.Sprintf("characters/%s_portrait.png", u.Name))
+	if err != nil {
+		d.logger().Errorf("Error loading character image portrait %v: %v", u.Name, err)
+		d.drawPortraitPlaceholder(canvas, u.Name, x, y, portraitSize)
+	} else {
+		if locked {
+			portrait = grayscale(portrait)
 		}
+		canvas.DrawImage(cropCircle(portrait), x, y)
+	}
+	if locked {
+		return
+	}
 
-		// Draw stars
-		starYellow, _ := bundle.loadUIAsset("ui/ap-5r-char-portrait_star-yellow.png")
-		starGray, _ := bundle.loadUIAsset("ui/ap-5r-char-portrait_star-gray.png")
-		if starYellow != nil {
-			cx, cy := x+(unitSize/4)+10, y+(unitSize/4)
-			rotate := []float64{0, -66, -43, -21, 0, 21, 43, 66}
-			for i := 1; i <= 7; i++ {
-				canvas.Push()
-				canvas.Stroke()
-				canvas.Translate(0.5, 0)
-				canvas.RotateAbout(gg.Radians(rotate[i]), f(cx), f(cy))
-				if u.Rarity >= i {
-					canvas.DrawImageAnchored(starYellow, cx, cy-26, 0.5, 0.5)
-				} else {
-					canvas.DrawImageAnchored(starGray, cx, cy-26, 0.5, 0.5)
-				}
-				canvas.Pop()
+	// Draw gear
+	gear, _ := bundle.loadUIAsset(fmt.Sprintf("ui/gear-icon-g%d_100x100.png", u.Gear))
+	if gear != nil {
+		canvas.DrawImage(gear, x, y)
+	}
+
+	// Draw stars
+	starYellow, _ := bundle.loadUIAsset("ui/ap-5r-char-portrait_star-yellow.png")
+	starGray, _ := bundle.loadUIAsset("ui/ap-5r-char-portrait_star-gray.png")
+	if starYellow != nil {
+		cx, cy := x+(unitSize/4)+10, y+(unitSize/4)
+		rotate := []float64{0, -66, -43, -21, 0, 21, 43, 66}
+		for i := 1; i <= 7; i++ {
+			canvas.Push()
+			canvas.Stroke()
+			canvas.Translate(0.5, 0)
+			canvas.RotateAbout(gg.Radians(rotate[i]), f(cx), f(cy))
+			if u.Rarity >= i {
+				canvas.DrawImageAnchored(starYellow, cx, cy-26, 0.5, 0.5)
+			} else {
+				canvas.DrawImageAnchored(starGray, cx, cy-26, 0.5, 0.5)
 			}
+			canvas.Pop()
 		}
+	}
 
-		// Check offset
-		x += unitSize
-		if (unitCount+1)%5 == 0 {
-			y += unitSize
-			x = padding
+	// Draw relic and zeta badges
+	if u.Relic.Tier > 2 {
+		color := gearColor(u)
+		if u.Data == nil {
+			color = gearColors[13]
 		}
+		d.drawBadge(canvas, color, fmt.Sprintf("%d", u.Relic.Tier-2), f(x+portraitSize-10), f(y+portraitSize-10))
 	}
+	if zetas := zetaCount(u); zetas > 0 {
+		d.drawBadge(canvas, "#9241ff", fmt.Sprintf("%dz", zetas), f(x+10), f(y+portraitSize-10))
+	}
+}
+
+// drawPortraitPlaceholder draws a circle with the unit name,
+// for units without a portrait image.
+func (d *drawer) drawPortraitPlaceholder(canvas *gg.Context, name string, x, y, size int) {
+	r := f(size) / 2
+	canvas.SetHexColor("#1B2D38")
+	canvas.DrawCircle(f(x)+r, f(y)+r, r)
+	canvas.Fill()
+	fontFace, _ := loadFont(14, true)
+	canvas.SetFontFace(fontFace)
+	canvas.SetHexColor("#ffffff")
+	canvas.DrawStringWrapped(name, f(x)+r, f(y)+r, 0.5, 0.5, f(size)-10, 1.2, gg.AlignCenter)
+}
+
+// drawBadge draws a small colored circle with a text centered at x, y.
+func (d *drawer) drawBadge(canvas *gg.Context, color, text string, x, y float64) {
+	canvas.SetHexColor("#0D1D25")
+	canvas.DrawCircle(x, y, 16)
+	canvas.Fill()
+	canvas.SetHexColor(color)
+	canvas.DrawCircle(x, y, 14)
+	canvas.Fill()
+	d.size, d.bold = 16, true
+	d.x, d.y = x+1, y+1
+	d.textCenter()
+	d.printf(canvas, "%s", text)
+}
+
+// zetaCount returns the number of zeta abilities the unit has.
+func zetaCount(u *swgohhelp.Unit) (count int) {
+	for _, skill := range u.Skills {
+		if skill.IsZeta && skill.Tier == 8 {
+			count++
+		}
+	}
+	return count
+}
+
+// modSlots are the mod slots in the order they are displayed,
+// left column first, like in the game screen.
+var modSlots = []swgohhelp.ModSlot{
+	swgohhelp.ModSlotSquare, swgohhelp.ModSlotDiamond, swgohhelp.ModSlotCircle,
+	swgohhelp.ModSlotArrow, swgohhelp.ModSlotTriangle, swgohhelp.ModSlotCross,
+}
+
+// modTierColors are the mod colors by tier, from grey (E) to gold (A).
+var modTierColors = map[int]string{
+	1: "#a0a0a0",
+	2: "#98fd33",
+	3: "#00bdfe",
+	4: "#9241ff",
+	5: "#ffd036",
+}
+
+// DrawUnitMods draws the six mod slots of the unit, with the mod shape, set,
+// dots, level, primary stat and the secondary stats with their roll count.
+func (d *drawer) DrawUnitMods(u *swgohhelp.Unit) ([]byte, error) {
+	panelWidth, panelHeight, padding := 430, 210, 20
+	width := padding*3 + panelWidth*2
+	height := 100 + (panelHeight+padding)*3 + 40
+
+	canvas := gg.NewContext(width, height)
+	canvas.SetHexColor("#0D1D25")
+	canvas.Clear()
+
+	// Draw unit name
+	d.size, d.bold = 34, true
+	d.x, d.y = f(width/2), 50
+	d.textCenter()
+	d.printf(canvas, "%s mods", u.Name)
+
+	mods := make(map[swgohhelp.ModSlot]swgohhelp.Mod)
+	for _, m := range u.Mods {
+		mods[m.Slot] = m
+	}
+	for i, slot := range modSlots {
+		px := f(padding + (i/3)*(panelWidth+padding))
+		py := f(100 + (i%3)*(panelHeight+padding))
+		canvas.SetHexColor("#1B2D38")
+		canvas.DrawRoundedRectangle(px, py, f(panelWidth), f(panelHeight), 10)
+		canvas.Fill()
+
+		m, ok := mods[slot]
+		if !ok {
+			d.color = "#a0a0a0"
+			d.size, d.bold = 24, false
+			d.x, d.y = px+f(panelWidth/2), py+f(panelHeight/2)
+			d.textCenter()
+			d.printf(canvas, "No %s mod", slot)
+			d.color = "#ffffff"
+			continue
+		}
+		d.drawMod(canvas, m, px, py)
+	}
+
+	// Draw player info at bottom
+	d.size, d.bold = 24, false
+	d.x, d.y = f(padding), f(height-30)
+	d.textLeft()
+	d.printf(canvas, "%s - ", d.player.Name)
+	d.x += d.advanceX
+	d.color = "#00bdfe"
+	d.printf(canvas, "%s", d.player.Titles.Selected)
+	d.color = "#ffffff"
 
 	var b bytes.Buffer
 	if err := canvas.EncodePNG(&b); err != nil {
@@ -304,6 +494,93 @@ func (d *drawer) DrawUnitList(units []swgohhelp.Unit) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// drawMod draws a single mod in the panel at px, py.
+func (d *drawer) drawMod(canvas *gg.Context, m swgohhelp.Mod, px, py float64) {
+	color, ok := modTierColors[m.Tier]
+	if !ok {
+		color = modTierColors[1]
+	}
+	cx, cy := px+70, py+95
+
+	// Draw dots
+	canvas.SetHexColor("#ffffff")
+	for i := 0; i < m.Pips; i++ {
+		canvas.DrawCircle(cx-f((m.Pips-1)*7)+f(i*14), py+25, 4)
+	}
+	canvas.Fill()
+
+	// Draw shape
+	canvas.SetHexColor(color)
+	drawModShape(canvas, m.Slot, cx, cy, 38)
+	canvas.Fill()
+
+	// Draw level and set
+	d.size, d.bold = 20, true
+	d.x, d.y = cx, py+155
+	d.textCenter()
+	d.printf(canvas, "Lv %d", m.Level)
+	d.bold = false
+	d.y += 30
+	d.printf(canvas, "%s", m.Set)
+
+	// Draw stats
+	d.size, d.bold = 24, true
+	d.x, d.y = px+150, py+35
+	d.textLeft()
+	d.printf(canvas, "%s", modStatValue(m.Primary, false))
+	d.size, d.bold = 22, false
+	for _, s := range m.Secondaries {
+		d.y += 40
+		if s.Unit == swgohhelp.StatSpeed {
+			d.color, d.bold = "#ffd036", true
+		}
+		d.printf(canvas, "%s", modStatValue(s, true))
+		d.color, d.bold = "#ffffff", false
+	}
+}
+
+// drawModShape adds the path of the mod slot shape, centered at cx, cy.
+func drawModShape(canvas *gg.Context, slot swgohhelp.ModSlot, cx, cy, r float64) {
+	switch slot {
+	case swgohhelp.ModSlotSquare:
+		canvas.DrawRectangle(cx-r*0.8, cy-r*0.8, r*1.6, r*1.6)
+	case swgohhelp.ModSlotArrow:
+		canvas.MoveTo(cx-r, cy+r*0.2)
+		canvas.LineTo(cx, cy-r)
+		canvas.LineTo(cx+r, cy+r*0.2)
+		canvas.LineTo(cx+r*0.35, cy+r*0.2)
+		canvas.LineTo(cx+r*0.35, cy+r)
+		canvas.LineTo(cx-r*0.35, cy+r)
+		canvas.LineTo(cx-r*0.35, cy+r*0.2)
+		canvas.ClosePath()
+	case swgohhelp.ModSlotDiamond:
+		canvas.DrawRegularPolygon(4, cx, cy, r, math.Pi/4)
+	case swgohhelp.ModSlotTriangle:
+		canvas.DrawRegularPolygon(3, cx, cy+r*0.2, r, 0)
+	case swgohhelp.ModSlotCircle:
+		canvas.DrawCircle(cx, cy, r*0.85)
+	case swgohhelp.ModSlotCross:
+		canvas.DrawRectangle(cx-r*0.3, cy-r, r*0.6, r*2)
+		canvas.DrawRectangle(cx-r, cy-r*0.3, r*2, r*0.6)
+	}
+}
+
+// modStatValue formats the mod stat value, like "+5.88% Offense" or "+12 Speed (3)".
+// Percent stats are returned by the API already multiplied by 100.
+func modStatValue(s swgohhelp.ModStat, showRoll bool) string {
+	name := s.Unit.String()
+	var v string
+	if strings.HasPrefix(name, "% ") {
+		v = fmt.Sprintf("+%.2f%% %s", s.Value, strings.TrimPrefix(name, "% "))
+	} else {
+		v = fmt.Sprintf("+%.0f %s", s.Value, name)
+	}
+	if showRoll && s.Roll > 0 {
+		v += fmt.Sprintf(" (%d)", s.Roll)
+	}
+	return v
+}
+
 func (d *drawer) printStatValue(canvas *gg.Context, v interface{}, m interface{}) {
 	switch v.(type) {
 	case int:
@@ -351,6 +628,14 @@ func (d *drawer) printf(canvas *gg.Context, format string, args ...interface{})
 	return nil
 }
 
+// logger returns the drawer logger.
+func (d *drawer) logger() *Logger {
+	if d.l != nil {
+		return d.l
+	}
+	return logger
+}
+
 func (d *drawer) textCenter() {
 	d.ax, d.ay = 0.5, 0.5
 }
@@ -382,11 +667,7 @@ func (a *assetBundle) loadUIAsset(file string) (image.Image, error) {
 }
 
 func loadAsset(file string) (image.Image, error) {
-	assetDir := os.Getenv("BOT_ASSET_DIR")
-	if assetDir == "" {
-		assetDir = "."
-	}
-	return gg.LoadPNG(assetDir + "/images/" + file)
+	return gg.LoadPNG(*assetDir + "/images/" + file)
 }
 
 func loadFont(size float64, bold bool) (font.Face, error) {
@@ -427,6 +708,12 @@ func (c *circle) At(x, y int) color.Color {
 	return color.Alpha{0}
 }
 
+func grayscale(src image.Image) image.Image {
+	dst := image.NewGray(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	return dst
+}
+
 func cropCircle(src image.Image) image.Image {
 	dst := image.NewRGBA(src.Bounds())
 	p := image.Point{X: 50, Y: 50}
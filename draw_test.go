@@ -115,6 +115,22 @@ func TestDrawUnitList(t *testing.T) {
 				Rarity: 7, Gear: 1, Level: 85,
 			},
 		},
+		"badges": []swgohhelp.Unit{
+			{
+				Name:   "Darth Traya",
+				Rarity: 7, Gear: 13, Level: 85,
+				Relic: swgohhelp.Relic{Tier: 9},
+				Skills: []swgohhelp.UnitSkill{
+					{IsZeta: true, Tier: 8}, {IsZeta: true, Tier: 8}, {IsZeta: true, Tier: 7},
+				},
+			},
+			{
+				Name: "Darth Revan",
+			},
+			{
+				Name: "TIE Advanced x1",
+			},
+		},
 	}
 
 	for team, units := range teams {
@@ -140,3 +156,61 @@ func TestDrawUnitList(t *testing.T) {
 		ioutil.WriteFile("/tmp/assets/big-unit-list.png", b, 0644)
 	}
 }
+
+func TestDrawUnitMods(t *testing.T) {
+	speed := func(v float64, roll int) swgohhelp.ModStat {
+		return swgohhelp.ModStat{Unit: swgohhelp.StatSpeed, Value: v, Roll: roll}
+	}
+	offense := swgohhelp.ModStat{Unit: swgohhelp.StatOffensePercentAdditive, Value: 1.25, Roll: 1}
+	health := swgohhelp.ModStat{Unit: swgohhelp.StatHealth, Value: 428, Roll: 1}
+	unit := swgohhelp.Unit{
+		Name:   "Darth Traya",
+		Rarity: 7, Gear: 12, Level: 85,
+		Mods: []swgohhelp.Mod{
+			{Slot: swgohhelp.ModSlotSquare, Set: swgohhelp.ModSetSpeed, Pips: 5, Tier: 5, Level: 15,
+				Primary:     swgohhelp.ModStat{Unit: swgohhelp.StatOffensePercentAdditive, Value: 5.88},
+				Secondaries: []swgohhelp.ModStat{speed(17, 4), offense, health, {Unit: swgohhelp.StatCriticalChancePercentAdditive, Value: 2.1, Roll: 1}}},
+			{Slot: swgohhelp.ModSlotArrow, Set: swgohhelp.ModSetSpeed, Pips: 5, Tier: 4, Level: 15,
+				Primary:     speed(30, 0),
+				Secondaries: []swgohhelp.ModStat{offense, health}},
+			{Slot: swgohhelp.ModSlotDiamond, Set: swgohhelp.ModSetHealth, Pips: 6, Tier: 5, Level: 15,
+				Primary:     swgohhelp.ModStat{Unit: swgohhelp.StatDefensePercentAdditive, Value: 11.75},
+				Secondaries: []swgohhelp.ModStat{speed(5, 1), health}},
+			{Slot: swgohhelp.ModSlotTriangle, Set: swgohhelp.ModSetPotency, Pips: 4, Tier: 3, Level: 12,
+				Primary: swgohhelp.ModStat{Unit: swgohhelp.StatCriticalDamage, Value: 36}},
+			{Slot: swgohhelp.ModSlotCircle, Set: swgohhelp.ModSetHealth, Pips: 5, Tier: 2, Level: 15,
+				Primary:     swgohhelp.ModStat{Unit: swgohhelp.StatMaxHealthPercentAdditive, Value: 5.88},
+				Secondaries: []swgohhelp.ModStat{speed(11, 2)}},
+		},
+	}
+	d := drawer{player: testPlayer}
+	b, err := d.DrawUnitMods(&unit)
+	if err != nil {
+		t.Fatalf("Unexpected error drawing mods: %v", err)
+	}
+	ioutil.WriteFile("/tmp/assets/mods.png", b, 0644)
+
+	if v := modStatValue(speed(17, 4), true); v != "+17 Speed (4)" {
+		t.Errorf("Unexpected speed value: %v", v)
+	}
+	if v := modStatValue(offense, false); v != "+1.25% Offense" {
+		t.Errorf("Unexpected offense value: %v", v)
+	}
+}
+
+func TestDrawArenaSquad(t *testing.T) {
+	units := []swgohhelp.Unit{
+		{Name: "Darth Traya", Rarity: 7, Gear: 13, Relic: swgohhelp.Relic{Tier: 7}},
+		{Name: "Darth Sion", Rarity: 7, Gear: 12},
+		{Name: "Darth Nihilus", Rarity: 7, Gear: 12},
+		{Name: "Sith Trooper", Rarity: 6, Gear: 11},
+		{Name: "Count Dooku", Rarity: 7, Gear: 12},
+	}
+	roles := []swgohhelp.SquadUnitType{swgohhelp.SquadUnitLeader, 1, 1, 1, 1}
+	d := drawer{player: testPlayer}
+	b, err := d.DrawArenaSquad(12, units, roles)
+	if err != nil {
+		t.Fatalf("Unexpected error drawing arena squad: %v", err)
+	}
+	ioutil.WriteFile("/tmp/assets/arena.png", b, 0644)
+}
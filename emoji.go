@@ -8,4 +8,6 @@ const (
 	emojiClock            = "⌚"
 	emojiQuestionMark     = "❓"
 	emojiFacePalm         = "🤦"
+	emojiNoEntry          = "⛔"
+	emojiStopSign         = "🛑"
 )
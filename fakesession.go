@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// FakeMessage is a message sent with a FakeSession.
+type FakeMessage struct {
+	ID        string
+	ChannelID string
+	Content   string
+	Embed     *discordgo.MessageEmbed
+	Files     []FakeFile
+}
+
+// FakeFile is a file attached to a FakeMessage.
+type FakeFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// FakeReaction is a reaction added with a FakeSession.
+type FakeReaction struct {
+	ChannelID string
+	MessageID string
+	Emoji     string
+}
+
+// FakeSession is an in-memory Session that records all messages and reactions
+// sent by the bot. The guilds, channels and members it knows about are
+// configured with AddGuild and AddMember.
+type FakeSession struct {
+	mu sync.Mutex
+
+	User        *discordgo.User
+	Guilds      map[string]*discordgo.Guild
+	Channels    map[string]*discordgo.Channel
+	Members     map[string]*discordgo.Member
+	Permissions map[string]int
+	History     map[string][]*discordgo.Message
+
+	Sent      []*FakeMessage
+	Deleted   []string
+	Reactions []FakeReaction
+	Left      []string
+
+	// OnSend, if set, is called for each message sent.
+	OnSend func(m *FakeMessage)
+
+	lastID int
+}
+
+// NewFakeSession creates an empty FakeSession logged in as the AP-5R bot user.
+func NewFakeSession() *FakeSession {
+	return &FakeSession{
+		User:        &discordgo.User{ID: "ap-5r", Username: "AP-5R", Bot: true},
+		Guilds:      make(map[string]*discordgo.Guild),
+		Channels:    make(map[string]*discordgo.Channel),
+		Members:     make(map[string]*discordgo.Member),
+		Permissions: make(map[string]int),
+		History:     make(map[string][]*discordgo.Message),
+	}
+}
+
+// AddGuild adds the guild and its channels.
+func (f *FakeSession) AddGuild(guild *discordgo.Guild, channels ...*discordgo.Channel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Guilds[guild.ID] = guild
+	for _, c := range channels {
+		c.GuildID = guild.ID
+		f.Channels[c.ID] = c
+	}
+}
+
+// AddMember adds the member to the guild, with the Discord permissions perms.
+func (f *FakeSession) AddMember(guildID string, member *discordgo.Member, perms int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	member.GuildID = guildID
+	f.Members[guildID+"/"+member.User.ID] = member
+	f.Permissions[member.User.ID] = perms
+}
+
+// Reset clears the recorded messages and reactions.
+func (f *FakeSession) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Sent, f.Deleted, f.Reactions, f.Left = nil, nil, nil, nil
+}
+
+// nextID returns a new message ID. Must be called with mu held.
+func (f *FakeSession) nextID() string {
+	f.lastID++
+	return strconv.Itoa(f.lastID)
+}
+
+func (f *FakeSession) BotUser() *discordgo.User {
+	return f.User
+}
+
+func (f *FakeSession) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	return f.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (f *FakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	m := &FakeMessage{ChannelID: channelID, Content: data.Content, Embed: data.Embed}
+	for _, file := range data.Files {
+		b, err := ioutil.ReadAll(file.Reader)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, FakeFile{Name: file.Name, ContentType: file.ContentType, Data: b})
+	}
+	f.mu.Lock()
+	m.ID = f.nextID()
+	f.Sent = append(f.Sent, m)
+	onSend := f.OnSend
+	f.mu.Unlock()
+	if onSend != nil {
+		onSend(m)
+	}
+	msg := &discordgo.Message{ID: m.ID, ChannelID: channelID, Content: m.Content, Author: f.User}
+	if m.Embed != nil {
+		msg.Embeds = []*discordgo.MessageEmbed{m.Embed}
+	}
+	return msg, nil
+}
+
+func (f *FakeSession) ChannelMessageDelete(channelID, messageID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Deleted = append(f.Deleted, messageID)
+	return nil
+}
+
+// ChannelMessages returns the History of the channel. Only the first page is returned.
+func (f *FakeSession) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) ([]*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if beforeID != "" {
+		return nil, nil
+	}
+	return f.History[channelID], nil
+}
+
+func (f *FakeSession) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Reactions = append(f.Reactions, FakeReaction{ChannelID: channelID, MessageID: messageID, Emoji: emojiID})
+	return nil
+}
+
+func (f *FakeSession) Channel(channelID string) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Channels[channelID]
+	if !ok {
+		return nil, fmt.Errorf("fake: unknown channel %s", channelID)
+	}
+	return c, nil
+}
+
+func (f *FakeSession) Guild(guildID string) (*discordgo.Guild, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, ok := f.Guilds[guildID]
+	if !ok {
+		return nil, fmt.Errorf("fake: unknown guild %s", guildID)
+	}
+	return g, nil
+}
+
+func (f *FakeSession) GuildChannels(guildID string) (channels []*discordgo.Channel, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.Channels {
+		if c.GuildID == guildID {
+			channels = append(channels, c)
+		}
+	}
+	return channels, nil
+}
+
+func (f *FakeSession) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.Members[guildID+"/"+userID]
+	if !ok {
+		return nil, fmt.Errorf("fake: unknown member %s", userID)
+	}
+	return m, nil
+}
+
+func (f *FakeSession) GuildLeave(guildID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Left = append(f.Left, guildID)
+	return nil
+}
+
+func (f *FakeSession) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}
+	f.Channels[c.ID] = c
+	return c, nil
+}
+
+func (f *FakeSession) UserChannelPermissions(userID, channelID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Permissions[userID], nil
+}
+
+func (f *FakeSession) UserGuilds(limit int, beforeID, afterID string) (guilds []*discordgo.UserGuild, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if afterID != "" {
+		return nil, nil
+	}
+	for _, g := range f.Guilds {
+		guilds = append(guilds, &discordgo.UserGuild{ID: g.ID, Name: g.Name})
+	}
+	return guilds, nil
+}
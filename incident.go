@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// maxReportSize keeps incident reports and long replies bellow the Discord message size limit.
+const maxReportSize = 1900
+
+// Incident is an unexpected error or panic while handling a command.
+type Incident struct {
+	ID    string
+	Err   error
+	Stack []byte
+}
+
+// newIncident creates an incident with a new random ID.
+func newIncident(err error, stack []byte) *Incident {
+	return &Incident{ID: randomID(), Err: err, Stack: stack}
+}
+
+// randomID returns a short random ID, like 1A2B3C4D.
+func randomID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return strings.ToUpper(fmt.Sprintf("%x", b))
+}
+
+func (i *Incident) Error() string {
+	return fmt.Sprintf("incident %s: %v", i.ID, i.Err)
+}
+
+// handledError is a command error already explained to the user,
+// like a player not found. It fails the command, but it is not an incident.
+type handledError struct {
+	err error
+}
+
+func (e *handledError) Error() string {
+	return e.err.Error()
+}
+
+// handled marks the error as already replied to the user.
+func handled(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &handledError{err: err}
+}
+
+// isIncident returns true if the handler error must be reported. Errors
+// that are already handled by the dispatcher middlewares, or replied
+// by the command, are not incidents.
+func isIncident(err error) bool {
+	switch err.(type) {
+	case *handledError:
+		return false
+	}
+	switch err {
+	case nil, errProfileRequered, errPermissionDenied, errRateLimited, errTimeout:
+		return false
+	}
+	return true
+}
+
+// withRecovery turns panics and errors from the handler into incidents,
+// logged and reported with an ID that users can use to report the issue.
+func withRecovery(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) (err error) {
+		defer func() {
+			var stack []byte
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+				stack = debug.Stack()
+			}
+			if !isIncident(err) {
+				return
+			}
+			inc := newIncident(err, stack)
+			r.l.With("incident", inc.ID).Errorf("INCIDENT %s: %v\n> author=%v args=%+v allyCode=%v\n%s",
+				inc.ID, inc.Err, r.m.Author, *r.args, r.allyCode, inc.Stack)
+			send(r.s, r.m.ChannelID, "Oh no %s, something went wrong in my circuits. "+
+				"If you want to report this, tell my master about the incident **%s**.", r.m.Author.Mention(), inc.ID)
+			reportIncident(r, inc)
+			err = inc
+		}()
+		return next.HandleCommand(r)
+	})
+}
+
+// reportIncident posts a summary of the incident to the -incident-channel,
+// or to the bot owners DMs if the channel is "dm".
+func reportIncident(r CmdRequest, inc *Incident) {
+	var channels []string
+	switch channel := conf().IncidentChannel; channel {
+	case "":
+		return
+	case "dm":
+		for _, owner := range conf().Owners {
+			c, err := r.s.UserChannelCreate(owner)
+			if err != nil {
+				logger.Errorf("Unable to open DM with owner %v: %v", owner, err)
+				continue
+			}
+			channels = append(channels, c.ID)
+		}
+	default:
+		channels = append(channels, channel)
+	}
+	msg := fmt.Sprintf("Incident **%s** at *%s* <#%s> by %s: `%s`\n%v\n```%s```",
+		inc.ID, r.guild.Name, r.m.ChannelID, r.m.Author, r.m.Content, inc.Err, inc.Stack)
+	if len(msg) > maxReportSize {
+		msg = msg[:maxReportSize] + "...```"
+	}
+	for _, c := range channels {
+		if _, err := r.s.ChannelMessageSend(c, msg); err != nil {
+			logger.Errorf("Unable to report incident %s to %v: %v", inc.ID, c, err)
+		}
+	}
+}
+
+// recoverEvent logs panics from Discord event handlers, so that a bad
+// event does not take the whole bot down.
+func recoverEvent(event string) {
+	if p := recover(); p != nil {
+		logger.Errorf("Recovered panic handling %s: %v\n%s", event, p, debug.Stack())
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestIncident(t *testing.T) {
+	inc := newIncident(errors.New("boom"), nil)
+	if !regexp.MustCompile("^[0-9A-F]{8}$").MatchString(inc.ID) {
+		t.Errorf("Unexpected incident ID: %v", inc.ID)
+	}
+	if other := newIncident(errors.New("boom"), nil); other.ID == inc.ID {
+		t.Errorf("Incident IDs should be unique, got %v twice", inc.ID)
+	}
+	for _, err := range []error{nil, errProfileRequered, errPermissionDenied, errRateLimited, errTimeout} {
+		if isIncident(err) {
+			t.Errorf("Unexpected incident for %v", err)
+		}
+	}
+	if isIncident(handled(errors.New("player not found"))) {
+		t.Errorf("Unexpected incident for a handled error")
+	}
+	if !isIncident(inc.Err) {
+		t.Errorf("Expected incident for %v", inc.Err)
+	}
+}
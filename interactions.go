@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordAPI is the Discord REST API used for interactions, which the
+// vendored discordgo does not support.
+var discordAPI = "https://discord.com/api/v10"
+
+// Interaction, response and option types, see
+// https://discord.com/developers/docs/interactions/receiving-and-responding
+const (
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+
+	responsePong                   = 1
+	responseChannelMessage         = 4
+	responseDeferredChannelMessage = 5
+
+	optionString  = 3
+	optionBoolean = 5
+	optionUser    = 6
+)
+
+// maxDescription is the maximum length of slash command descriptions.
+const maxDescription = 100
+
+// Interaction is a Discord interaction webhook request.
+type Interaction struct {
+	ID            string            `json:"id"`
+	ApplicationID string            `json:"application_id"`
+	Type          int               `json:"type"`
+	Token         string            `json:"token"`
+	GuildID       string            `json:"guild_id"`
+	ChannelID     string            `json:"channel_id"`
+	Member        *discordgo.Member `json:"member"`
+	User          *discordgo.User   `json:"user"`
+	Data          *InteractionData  `json:"data"`
+}
+
+// InteractionData is the slash command invoked and its options.
+type InteractionData struct {
+	Name    string              `json:"name"`
+	Options []InteractionOption `json:"options"`
+}
+
+// InteractionOption is a slash command option value.
+type InteractionOption struct {
+	Name  string      `json:"name"`
+	Type  int         `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// Author returns the user who invoked the interaction.
+func (i *Interaction) Author() *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// Args maps the slash command options to the command arguments.
+func (i *Interaction) Args() (*Args, []*discordgo.User) {
+	args := &Args{Command: strings.ToLower(i.Data.Name)}
+	var mentions []*discordgo.User
+	for _, o := range i.Data.Options {
+		switch o.Type {
+		case optionBoolean:
+			if v, _ := o.Value.(bool); v {
+				args.Flags = append(args.Flags, "+"+strings.ToLower(o.Name))
+			}
+		case optionUser:
+			mentions = append(mentions, &discordgo.User{ID: fmt.Sprint(o.Value)})
+		default:
+			switch o.Name {
+			case "name":
+				args.Name = strings.TrimSpace(fmt.Sprint(o.Value))
+			case "profile":
+				args.Profile = strings.Trim(fmt.Sprint(o.Value), "[] ")
+			}
+		}
+	}
+	args.Line = strings.TrimSpace(conf().Prefix + args.Command + " " + args.Name)
+	if args.Profile != "" {
+		args.Line += " [" + args.Profile + "]"
+	}
+	for _, f := range args.Flags {
+		args.Line += " " + f
+	}
+	return args, mentions
+}
+
+// ApplicationCommand is a slash command definition.
+type ApplicationCommand struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Options     []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+// ApplicationCommandOption is a slash command option definition.
+type ApplicationCommandOption struct {
+	Type        int    `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// applicationCommandNameRe are the valid slash command and option names.
+var applicationCommandNameRe = regexp.MustCompile("^[a-z0-9_-]{1,32}$")
+
+// applicationCommands generates the slash command definitions of the
+// visible commands. The command Usage becomes the name option, and
+// each flag becomes a boolean option, skipping the short aliases.
+func applicationCommands(cmds []*Command) (result []ApplicationCommand) {
+	for _, cmd := range cmds {
+		if cmd.Hidden || !applicationCommandNameRe.MatchString(cmd.Name) {
+			continue
+		}
+		ac := ApplicationCommand{
+			Name:        cmd.Name,
+			Description: truncate(cmd.Description, maxDescription),
+		}
+		if cmd.Usage != "" {
+			usage := strings.Replace(cmd.Usage, "*", "", -1)
+			ac.Options = append(ac.Options, ApplicationCommandOption{
+				Type:        optionString,
+				Name:        "name",
+				Description: truncate(usage, maxDescription),
+				Required:    !strings.HasPrefix(usage, "["),
+			})
+		}
+		if cmd.ProfileArg {
+			ac.Options = append(ac.Options, ApplicationCommandOption{
+				Type:        optionString,
+				Name:        "profile",
+				Description: "ally code, swgoh.gg profile or account name",
+			})
+		}
+		seen := make(map[string]bool)
+		for _, f := range cmd.Flags {
+			name := strings.ToLower(strings.TrimPrefix(f, "+"))
+			if seen[name] || isFlagAlias(cmd.Flags, name) || !applicationCommandNameRe.MatchString(name) {
+				continue
+			}
+			seen[name] = true
+			ac.Options = append(ac.Options, ApplicationCommandOption{
+				Type:        optionBoolean,
+				Name:        name,
+				Description: fmt.Sprintf("same as %s", f),
+			})
+		}
+		result = append(result, ac)
+	}
+	return result
+}
+
+// isFlagAlias returns true if name is a short version of other flag, like +s for +ships.
+func isFlagAlias(flags []string, name string) bool {
+	for _, f := range flags {
+		f = strings.ToLower(strings.TrimPrefix(f, "+"))
+		if f != name && strings.HasPrefix(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
+
+// InteractionServer handles the Discord interactions webhook, running the
+// slash commands with the dispatcher. Commands run in the background after
+// a deferred response, and their messages are sent as follow-ups.
+type InteractionServer struct {
+	PublicKey  ed25519.PublicKey
+	Session    Session
+	Dispatcher *CmdDispatcher
+
+	pending sync.WaitGroup
+}
+
+// Wait blocks until all commands in progress are finished, or ctx is done.
+func (srv *InteractionServer) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		srv.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (srv *InteractionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !verifyInteraction(srv.PublicKey, r.Header, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+	var i Interaction
+	if err := json.Unmarshal(body, &i); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case i.Type == interactionPing:
+		writeInteractionResponse(w, responsePong, nil)
+	case i.Type != interactionApplicationCommand || i.Data == nil || i.Author() == nil:
+		http.Error(w, "unsupported interaction", http.StatusBadRequest)
+	case i.GuildID == "":
+		writeInteractionResponse(w, responseChannelMessage, &discordgo.MessageSend{
+			Content: "Sorry, I only answer commands in servers.",
+		})
+	default:
+		writeInteractionResponse(w, responseDeferredChannelMessage, nil)
+		srv.pending.Add(1)
+		go func() {
+			defer srv.pending.Done()
+			defer recoverEvent("interaction")
+			srv.dispatch(&i)
+		}()
+	}
+}
+
+// dispatch runs the interaction command, sending the reaction
+// as the reply if the command did not send any message.
+func (srv *InteractionServer) dispatch(i *Interaction) {
+	args, mentions := i.Args()
+	s := &interactionSession{Session: srv.Session, appID: i.ApplicationID, token: i.Token}
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        i.ID,
+		ChannelID: i.ChannelID,
+		GuildID:   i.GuildID,
+		Content:   args.Line,
+		Author:    i.Author(),
+		Mentions:  mentions,
+	}}
+	err := srv.Dispatcher.DispatchArgs(s, m, args)
+	if err == errShuttingDown {
+		s.ChannelMessageSend(i.ChannelID, "I'm restarting my circuits, please try again in a minute.")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Unable to handle interaction %v: %v", args.Line, err)
+	}
+	if s.sent == 0 && s.reaction != "" {
+		s.ChannelMessageSend(i.ChannelID, s.reaction)
+	}
+}
+
+// verifyInteraction checks the Ed25519 signature of the request.
+func verifyInteraction(key ed25519.PublicKey, h http.Header, body []byte) bool {
+	sig, err := hex.DecodeString(h.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	msg := append([]byte(h.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(key, msg, sig)
+}
+
+// writeInteractionResponse writes the JSON interaction response.
+func writeInteractionResponse(w http.ResponseWriter, responseType int, data *discordgo.MessageSend) {
+	resp := map[string]interface{}{"type": responseType}
+	if data != nil {
+		resp["data"] = data
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// interactionSession sends the messages as follow-ups of the interaction.
+// The first message replaces the deferred response. Reactions are kept,
+// as there is no user message to react to.
+type interactionSession struct {
+	Session
+	appID, token string
+
+	mu       sync.Mutex
+	sent     int
+	reaction string
+}
+
+func (s *interactionSession) BotUser() *discordgo.User {
+	return &discordgo.User{ID: s.appID}
+}
+
+func (s *interactionSession) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	return s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content})
+}
+
+func (s *interactionSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	s.mu.Lock()
+	first := s.sent == 0
+	s.sent++
+	s.mu.Unlock()
+	method, url := "POST", fmt.Sprintf("%s/webhooks/%s/%s", discordAPI, s.appID, s.token)
+	if first {
+		method, url = "PATCH", url+"/messages/@original"
+	}
+	payload := map[string]interface{}{"content": data.Content}
+	if data.Embed != nil {
+		payload["embeds"] = []*discordgo.MessageEmbed{data.Embed}
+	}
+	var m discordgo.Message
+	if err := discordRequest(method, url, "", payload, data.Files, &m); err != nil {
+		return nil, err
+	}
+	if first {
+		m.ID = "@original"
+	}
+	m.ChannelID = channelID
+	return &m, nil
+}
+
+func (s *interactionSession) ChannelMessageDelete(channelID, messageID string) error {
+	url := fmt.Sprintf("%s/webhooks/%s/%s/messages/%s", discordAPI, s.appID, s.token, messageID)
+	return discordRequest("DELETE", url, "", nil, nil, nil)
+}
+
+func (s *interactionSession) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reaction = emojiID
+	return nil
+}
+
+// discordRequest calls the Discord API with the JSON payload, or with a
+// multipart body if there are files, and decodes the response into v.
+func discordRequest(method, url, auth string, payload interface{}, files []*discordgo.File, v interface{}) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+	if len(files) > 0 {
+		mw := multipart.NewWriter(&body)
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := mw.WriteField("payload_json", string(p)); err != nil {
+			return err
+		}
+		for n, f := range files {
+			fw, err := mw.CreateFormFile(fmt.Sprintf("files[%d]", n), f.Name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, f.Reader); err != nil {
+				return err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+		contentType = mw.FormDataContentType()
+	} else if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord: unexpected status %s calling %s %s: %s", resp.Status, method, url, b)
+	}
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// registerApplicationCommands replaces the slash commands of the application
+// with the definitions generated from the dispatcher commands.
+// If guildID is not empty, the commands are registered only in that server,
+// where they are available immediately.
+func registerApplicationCommands(appID, guildID string) (int, error) {
+	url := fmt.Sprintf("%s/applications/%s/commands", discordAPI, appID)
+	if guildID != "" {
+		url = fmt.Sprintf("%s/applications/%s/guilds/%s/commands", discordAPI, appID, guildID)
+	}
+	cmds := applicationCommands(dispatcher.Commands())
+	return len(cmds), discordRequest("PUT", url, "Bot "+*token, cmds, nil, nil)
+}
+
+// cmdRegisterCommands registers the slash commands, globally or in the current server.
+func cmdRegisterCommands(r CmdRequest) (err error) {
+	if *appID == "" {
+		_, err = send(r.s, r.m.ChannelID, "I need the application ID to register the slash commands, see -app-id.")
+		return err
+	}
+	guildID, where := "", "in all servers (it may take up to an hour to show up)"
+	if strings.ToLower(r.args.Name) == "here" {
+		guildID, where = r.guild.ID, "in this server"
+	}
+	n, err := registerApplicationCommands(*appID, guildID)
+	if err != nil {
+		send(r.s, r.m.ChannelID, "Oops, I was unable to register the slash commands: %v", err)
+		return err
+	}
+	_, err = send(r.s, r.m.ChannelID, "Registered %d slash commands %s.", n, where)
+	return err
+}
+
+// serveInteractions listens in the background for the Discord interactions
+// webhook at addr, until the returned server is shut down.
+// Commands use a Discord session without the websocket, for the API calls.
+func serveInteractions(addr string) (*http.Server, *InteractionServer) {
+	key, err := hex.DecodeString(*appPublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		logger.Fatalf("Error initializing interactions: invalid application public key")
+	}
+	dg, err := discordgo.New("Bot " + *token)
+	if err != nil {
+		logger.Fatalf("Error initializing interactions: %v", err)
+	}
+	interactions := &InteractionServer{
+		PublicKey:  key,
+		Session:    newSession(dg),
+		Dispatcher: dispatcher,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/interactions", interactions)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	logger.Printf("Listening for interactions at %v/interactions", addr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Error listening for interactions: %v", err)
+		}
+	}()
+	return srv, interactions
+}
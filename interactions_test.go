@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+func TestApplicationCommands(t *testing.T) {
+	cmds := applicationCommands(dispatcher.Commands())
+	byName := make(map[string]ApplicationCommand)
+	for _, c := range cmds {
+		byName[c.Name] = c
+		if len(c.Description) > maxDescription || c.Description == "" {
+			t.Errorf("Invalid description for %s: %q", c.Name, c.Description)
+		}
+	}
+	if _, ok := byName["leave-guild"]; ok {
+		t.Errorf("Unexpected hidden command leave-guild")
+	}
+	var options []string
+	for _, o := range byName["stats"].Options {
+		options = append(options, o.Name)
+	}
+	if strings.Join(options, ",") != "name,profile,ships" {
+		t.Errorf("Unexpected stats options: %v", options)
+	}
+	if !byName["stats"].Options[0].Required || byName["help"].Options[0].Required {
+		t.Errorf("Unexpected required name options: %#v %#v", byName["stats"].Options[0], byName["help"].Options[0])
+	}
+}
+
+// fakeDiscordAPI records the follow-up messages sent to the webhooks API.
+type fakeDiscordAPI struct {
+	mu       sync.Mutex
+	requests []string
+	payloads []map[string]interface{}
+	files    []string
+}
+
+func (f *fakeDiscordAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	payload := make(map[string]interface{})
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(1 << 20)
+		json.Unmarshal([]byte(r.FormValue("payload_json")), &payload)
+		for _, files := range r.MultipartForm.File {
+			for _, fh := range files {
+				f.files = append(f.files, fh.Filename)
+			}
+		}
+	} else {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}
+	f.payloads = append(f.payloads, payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"id": "1"}`))
+}
+
+func TestInteractionServer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeDiscordAPI{}
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+	defer func(url string) { discordAPI = url }(discordAPI)
+	discordAPI = apiServer.URL
+
+	h := newTestHarness(t)
+	h.data.AddPlayer(&swgohhelp.Player{
+		Name:     "Player",
+		AllyCode: 123456789,
+		Roster: swgohhelp.Roster{{
+			Name:   "Darth Vader",
+			Rarity: 7, Gear: 12, Level: 85,
+			Stats: &swgohhelp.UnitStats{},
+		}},
+	})
+	srv := &InteractionServer{PublicKey: pub, Session: h.s, Dispatcher: h.d}
+
+	post := func(body string, sign bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/interactions", strings.NewReader(body))
+		if sign {
+			req.Header.Set("X-Signature-Timestamp", "1600000000")
+			sig := ed25519.Sign(priv, append([]byte("1600000000"), body...))
+			req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(sig))
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		srv.Wait(context.Background())
+		return w
+	}
+
+	if w := post(`{"type": 1}`, false); w.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status for unsigned request: %v", w.Code)
+	}
+	if w := post(`{"type": 1}`, true); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"type":1`) {
+		t.Errorf("Unexpected ping response: %v %v", w.Code, w.Body)
+	}
+
+	interaction := func(name string, options ...InteractionOption) string {
+		b, _ := json.Marshal(&Interaction{
+			ID: "interaction", ApplicationID: "app", Type: interactionApplicationCommand, Token: "token",
+			GuildID: h.cache.guildID, ChannelID: h.channel.ID,
+			Member: &discordgo.Member{User: &discordgo.User{ID: "user", Username: "user"}},
+			Data:   &InteractionData{Name: name, Options: options},
+		})
+		return string(b)
+	}
+	w := post(interaction("stats",
+		InteractionOption{Name: "name", Type: optionString, Value: "darth vader"},
+		InteractionOption{Name: "profile", Type: optionString, Value: "123-456-789"},
+		InteractionOption{Name: "ships", Type: optionBoolean, Value: false}), true)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"type":5`) {
+		t.Errorf("Unexpected deferred response: %v %v", w.Code, w.Body)
+	}
+	if len(api.requests) != 1 || api.requests[0] != "PATCH /webhooks/app/token/messages/@original" {
+		t.Fatalf("Unexpected follow-up requests: %v", api.requests)
+	}
+	if !strings.Contains(api.payloads[0]["content"].(string), "Wow, nice stats") || len(api.files) != 1 {
+		t.Errorf("Unexpected follow-up: %v (files %v)", api.payloads[0], api.files)
+	}
+
+	api.requests, api.payloads = nil, nil
+	post(interaction("not-a-command"), true)
+	if len(api.requests) != 1 || api.payloads[0]["content"] != emojiQuestionMark {
+		t.Errorf("Unexpected follow-up for unknown command: %v %v", api.requests, api.payloads)
+	}
+
+	var dm bytes.Buffer
+	json.NewEncoder(&dm).Encode(&Interaction{Type: interactionApplicationCommand,
+		User: &discordgo.User{ID: "user"}, Data: &InteractionData{Name: "help"}})
+	if w := post(dm.String(), true); !strings.Contains(w.Body.String(), "only answer commands in servers") {
+		t.Errorf("Unexpected response to direct message: %v", w.Body)
+	}
+}
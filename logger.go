@@ -1,32 +1,205 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Logger is a guild-prefixed logger for info and error messages.
+// Level is the severity of a log entry.
+type Level int32
+
+// Log levels, from the most verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelFatal {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s, like debug or WARN.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("logger: unknown level %q", s)
+}
+
+var (
+	// logOutput is where the log entries are written.
+	logOutput io.Writer = os.Stderr
+	logMu     sync.Mutex
+	minLevel  = int32(LevelInfo)
+)
+
+// SetLogLevel changes the minimum level of the entries logged.
+func SetLogLevel(l Level) {
+	atomic.StoreInt32(&minLevel, int32(l))
+}
+
+// LogLevel returns the minimum level of the entries logged.
+func LogLevel() Level {
+	return Level(atomic.LoadInt32(&minLevel))
+}
+
+// Logger is a guild-prefixed logger, with optional fields added to all entries.
+// Entries are written as text, or as JSON objects with -log-format json.
 type Logger struct {
-	Guild string
+	Guild  string
+	fields []interface{}
+}
+
+// With returns a copy of the logger that adds the key and value pairs
+// to its entries.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{Guild: l.Guild, fields: fields}
+}
+
+// Debugf formats the message with args and logs with DEBUG level.
+func (l *Logger) Debugf(m string, args ...interface{}) {
+	l.log(LevelDebug, m, args...)
 }
 
 // Printf formats the message with args and logs with INFO level.
 func (l *Logger) Printf(m string, args ...interface{}) {
-	l.Infof(m, args...)
+	l.log(LevelInfo, m, args...)
 }
 
 // Infof formats the message with args and logs with INFO level.
 func (l *Logger) Infof(m string, args ...interface{}) {
-	log.Printf(fmt.Sprintf("INFO  [%s] %s", l.Guild, m), args...)
+	l.log(LevelInfo, m, args...)
+}
+
+// Warnf formats the message with args and logs with WARN level.
+func (l *Logger) Warnf(m string, args ...interface{}) {
+	l.log(LevelWarn, m, args...)
 }
 
 // Errorf formats the message with args and logs with ERROR level.
 func (l *Logger) Errorf(m string, args ...interface{}) {
-	log.Printf(fmt.Sprintf("ERROR [%s] %s", l.Guild, m), args...)
+	l.log(LevelError, m, args...)
 }
 
-// Fatalf formats the message and calls log.Fatalf with a FATAL level.
+// Fatalf formats the message and logs with a FATAL level.
 // Program will terminate after this call.
 func (l *Logger) Fatalf(m string, args ...interface{}) {
-	log.Fatalf(fmt.Sprintf("FATAL [%s] %s", l.Guild, m), args...)
+	l.log(LevelFatal, m, args...)
+	os.Exit(1)
+}
+
+// log writes the entry if the level is enabled.
+func (l *Logger) log(level Level, m string, args ...interface{}) {
+	if level < LogLevel() {
+		return
+	}
+	msg := fmt.Sprintf(m, args...)
+	now := time.Now()
+	var b bytes.Buffer
+	if conf().LogFormat == "json" {
+		l.writeJSON(&b, now, level, msg)
+	} else {
+		l.writeText(&b, now, level, msg)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput.Write(b.Bytes())
+}
+
+// writeText formats the entry as a line of text, with the fields as key=value.
+func (l *Logger) writeText(b *bytes.Buffer, now time.Time, level Level, msg string) {
+	fmt.Fprintf(b, "%s %-5s [%s] %s", now.Format("2006/01/02 15:04:05"), level, l.Guild, msg)
+	for i := 0; i < len(l.fields); i += 2 {
+		v := fmt.Sprint(l.fieldValue(i))
+		if v == "" || strings.ContainsAny(v, " \"=\n") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(b, " %v=%s", l.fields[i], v)
+	}
+	b.WriteByte('\n')
+}
+
+// writeJSON formats the entry as a JSON object in a single line.
+func (l *Logger) writeJSON(b *bytes.Buffer, now time.Time, level Level, msg string) {
+	entry := map[string]interface{}{
+		"time":  now.Format(time.RFC3339Nano),
+		"level": strings.ToLower(level.String()),
+		"guild": l.Guild,
+		"msg":   msg,
+	}
+	for i := 0; i < len(l.fields); i += 2 {
+		switch v := l.fieldValue(i).(type) {
+		case string, bool, int, int64, float64:
+			entry[fmt.Sprint(l.fields[i])] = v
+		default:
+			entry[fmt.Sprint(l.fields[i])] = fmt.Sprint(v)
+		}
+	}
+	if err := json.NewEncoder(b).Encode(entry); err != nil {
+		fmt.Fprintf(b, "{\"level\":\"error\",\"msg\":%q}\n", "unable to encode log entry: "+err.Error())
+	}
+}
+
+// fieldValue returns the value of the field key at i, or nil if missing.
+func (l *Logger) fieldValue(i int) interface{} {
+	if i+1 < len(l.fields) {
+		return l.fields[i+1]
+	}
+	return nil
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx that carries the logger.
+func withLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// contextLogger returns the logger in ctx, or fallback if there is none,
+// so the request fields are logged by the code it calls.
+func contextLogger(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// cmdLogLevel displays or changes the log level.
+func cmdLogLevel(r CmdRequest) error {
+	name := strings.TrimSpace(r.args.Name)
+	if name == "" {
+		send(r.s, r.m.ChannelID, "Log level is %v.", LogLevel())
+		return nil
+	}
+	level, err := ParseLevel(name)
+	if err != nil {
+		send(r.s, r.m.ChannelID, "Unknown log level. Use one of: %s.", strings.Join(levelNames[:LevelFatal], ", "))
+		return nil
+	}
+	SetLogLevel(level)
+	logger.Warnf("Log level changed to %v by %v", level, r.m.Author)
+	send(r.s, r.m.ChannelID, "Log level changed to %v.", level)
+	return nil
 }
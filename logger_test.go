@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the log entries to a buffer until the test ends.
+func captureLogs(t *testing.T, level Level, format string) *bytes.Buffer {
+	var b bytes.Buffer
+	setTestConfig(t, func(c *Config) {
+		c.LogLevel, c.LogFormat = level, format
+	})
+	logMu.Lock()
+	old := logOutput
+	logOutput = &b
+	logMu.Unlock()
+	t.Cleanup(func() {
+		logMu.Lock()
+		logOutput = old
+		logMu.Unlock()
+	})
+	return &b
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		l, err := ParseLevel(name)
+		if err != nil || !strings.EqualFold(l.String(), name) {
+			t.Errorf("Unexpected level for %v: %v (err=%v)", name, l, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("Expected error for unknown level")
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	b := captureLogs(t, LevelInfo, "text")
+
+	l := (&Logger{Guild: "Guild"}).With("request", "ABC", "user", "some user")
+	l.Debugf("hidden")
+	l.Infof("hello %d", 1)
+	out := b.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Unexpected debug entry: %q", out)
+	}
+	for _, expected := range []string{"INFO  [Guild] hello 1", `request=ABC`, `user="some user"`} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Log entry missing %q: %q", expected, out)
+		}
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	b := captureLogs(t, LevelDebug, "json")
+
+	l := (&Logger{Guild: "Guild"}).With("request", "ABC", "attempt", 2)
+	ctx := withLogger(context.Background(), l)
+	contextLogger(ctx, logger).Debugf("hello")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("Unexpected error parsing %q: %v", b.String(), err)
+	}
+	expected := map[string]interface{}{"level": "debug", "guild": "Guild", "msg": "hello", "request": "ABC", "attempt": 2.0}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("Unexpected %v: %v, expected %v", k, entry[k], v)
+		}
+	}
+	if contextLogger(context.Background(), logger) != logger {
+		t.Errorf("Expected fallback logger without a logger in the context")
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	b := captureLogs(t, LevelInfo, "text")
+
+	h := newTestHarness(t)
+	h.send("user", "/help")
+	for _, expected := range []string{"RECV:", "DONE: help", "request=", "guild_id=" + h.cache.guildID, "user=user", "command=help"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Request logs missing %q:\n%s", expected, b.String())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,101 +22,336 @@ import (
 )
 
 var (
+	configPath = flag.String("config", os.Getenv("BOT_CONFIG"), "JSON config `file`, with the flag names as keys. Flags and environment variables override it.")
+
 	token   = flag.String("token", os.Getenv("BOT_TOKEN"), "Token to connect to the discord api.")
 	devMode = flag.Bool("dev", asBool(os.Getenv("USE_DEV")), "Use development mode.")
 	apiUser = flag.String("username", os.Getenv("API_USERNAME"), "Username to be used to contact api.swgoh.help.")
 	apiPass = flag.String("password", os.Getenv("API_PASSWORD"), "Password to be used to contact api.swgoh.help.")
 
-	cmdPrefix  = flag.String("cmd-prefix", "/", "The command `prefix` to be used by the bot")
-	guildCache = make(map[string]*Cache)
-	apiCache   = NewAPICache()
-
-	logger = &Logger{Guild: "~MAIN~"}
-
-	renderPageHost = "http://localhost:8080"
-
-	dispatcher = NewDispatcher()
+	dataSource = flag.String("data-source", "swgohhelp", "The player data `source`: swgohhelp, swgoh.gg or appspot.")
+
+	owners          = flag.String("owners", os.Getenv("BOT_OWNERS"), "Comma separated `list` of Discord user IDs of the bot owners.")
+	incidentChannel = flag.String("incident-channel", os.Getenv("BOT_INCIDENT_CHANNEL"),
+		"Discord channel `ID` where incident reports are posted, or dm to send them to the bot owners.")
+	cacheDir = flag.String("cache-dir", os.Getenv("BOT_CACHE_DIR"), "The `directory` where the bot database is saved.")
+	assetDir = flag.String("asset-dir", envOr("BOT_ASSET_DIR", "."), "The `directory` with the images used to draw.")
+
+	registryChannel = flag.String("registry-channel", defaultRegistryChannel, "The default `name` of the channel where players post their profile links.")
+	embedColorHex   = flag.String("embed-color", "#00d1db", "The default embed `color`.")
+
+	cmdPrefix    = flag.String("cmd-prefix", "/", "The command `prefix` to be used by the bot")
+	cmdTimeout   = flag.Duration("cmd-timeout", 2*time.Minute, "The default `timeout` for commands to finish")
+	drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "How `long` to wait for the running commands when shutting down")
+	shards       = flag.Int("shards", asInt(os.Getenv("BOT_SHARDS")), "The `number` of gateway shards. Uses the Discord recommended count if zero.")
+	guildCache   = make(map[string]*Cache)
+	guildCacheMu sync.Mutex
+	apiCache     = NewAPICache()
+	store        *Store
+
+	usageRetention = flag.Duration("usage-retention", 30*24*time.Hour, "How `long` the command usage records are kept, for /bot-stats.")
+
+	logger    = &Logger{Guild: "~MAIN~"}
+	logLevel  = flag.String("log-level", os.Getenv("BOT_LOG_LEVEL"), "The minimum `level` logged: debug, info, warn or error. Defaults to info.")
+	logFormat = flag.String("log-format", os.Getenv("BOT_LOG_FORMAT"), "The log `format`: text or json. Defaults to text.")
+
+	pageRenderHost = flag.String("pagerender-host", defaultPageRenderHost(),
+		"The PageRender service `URL`. Defaults to the linked pagerender container, or http://localhost:8080.")
+	pageRenderFallback = flag.Bool("pagerender-fallback", os.Getenv("PAGERENDER_PORT_8080_TCP_ADDR") != "",
+		"Use PageRender screenshots when an image can't be drawn from the game data.")
+	httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+	interactionsAddr = flag.String("interactions-addr", os.Getenv("BOT_INTERACTIONS_ADDR"),
+		"The `address` to listen for Discord interactions (slash commands), like :8081. Disabled if empty.")
+	appID        = flag.String("app-id", os.Getenv("BOT_APP_ID"), "The Discord application `ID`, used for slash commands.")
+	appPublicKey = flag.String("app-public-key", os.Getenv("BOT_PUBLIC_KEY"), "The Discord application public `key`, used to verify interactions.")
+
+	statusAddr = flag.String("status-addr", os.Getenv("BOT_STATUS_ADDR"),
+		"The `address` to serve /healthz and /metrics, like :9090. Disabled if empty.")
+
+	console          = flag.Bool("console", false, "Run the commands typed in the standard input instead of connecting to Discord. Same as the repl argument.")
+	consoleUser      = flag.String("console-user", "console", "The user `name` that runs the commands in console mode.")
+	consoleGuild     = flag.String("console-guild", "Console", "The server `name` used in console mode.")
+	consoleAllyCodes = flag.String("console-ally-codes", "", "Comma separated `list` of ally codes linked in console mode, as user=123456789 or just the console user ally code.")
+	consoleData      = flag.String("console-data", "", "JSON fixture `file` with the player data used in console mode, instead of the data source.")
+	consoleOut       = flag.String("console-out", ".", "The `directory` where images are saved in console mode.")
+
+	dispatcher   = NewDispatcher()
+	rateLimiter  = NewRateLimiter()
+	shardManager *ShardManager
 )
 
 // init is called before main, after var block is defined.
 // This function will setup the commands to be used in the bot
 // main program.
 func init() {
-	dispatcher.Handle("help", CmdFunc(cmdHelp))
-	dispatcher.Handle("arena", CmdFunc(cmdArena))
-	dispatcher.Handle("stats", CmdFunc(cmdStats))
-	dispatcher.Handle("info", CmdFunc(cmdStats))
-	dispatcher.Handle("mods", CmdFunc(cmdMods))
-	dispatcher.Handle("faction", CmdFunc(cmdFaction))
-	dispatcher.Handle("lookup", cmdDisabled(
-		"This command is returning empty/stale results. "+
-			"My master is working to bring it back soon...")) //CmdFunc(cmdLookup))
-	dispatcher.Handle("server-info", cmdDisabled(
-		"~~i was doing a DDoS~~ the command was consuming too many resources;"+
-			" it will be back soon")) // CmdFunc(cmdServerInfo))
-	dispatcher.Handle("share-this-bot", CmdFunc(cmdShareThisBot))
+	dispatcher.Handle(&Command{
+		Name:        "help",
+		Usage:       "*[command]*",
+		Description: "list my commands, or explain how to use one of them.",
+		Examples:    []string{"help", "help stats"},
+		Handler:     CmdFunc(cmdHelp),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "arena",
+		Description: "display your current arena team. Add +fleet to see your fleet arena.",
+		Flags:       []string{"+more", "+fleet"},
+		Examples:    []string{"arena", "arena +more", "arena +fleet"},
+		ProfileArg:  true,
+		Handler:     CmdFunc(cmdArena),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "stats",
+		Aliases:     []string{"info"},
+		Usage:       "*character*",
+		Description: "display character basic stats.",
+		Flags:       []string{"+ships", "+ship", "+s"},
+		Examples:    []string{"stats tie fighter pilot", "stats bb8 [123-456-789]"},
+		ProfileArg:  true,
+		Handler:     CmdFunc(cmdStats),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "mods",
+		Usage:       "*character*",
+		Description: "display the mods you have on a character.",
+		Examples:    []string{"mods rey"},
+		ProfileArg:  true,
+		Handler:     CmdFunc(cmdMods),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "faction",
+		Usage:       "*faction*",
+		Description: "display an image of your characters in the given faction.",
+		Flags:       []string{"+ships", "+ship", "+s"},
+		Examples:    []string{"faction rebels", "faction empire +ships"},
+		ProfileArg:  true,
+		Handler:     CmdFunc(cmdFaction),
+	})
+	dispatcher.Handle(&Command{
+		Name:          "lookup",
+		Usage:         "*character*",
+		Description:   "search and see who has a specific character.",
+		Flags:         []string{"+1star", "+7star", "+g1", "+g12", "+ships"},
+		Cost:          5,
+		Cooldown:      5 * time.Minute,
+		CooldownScope: ScopeGuild,
+		Handler:       CmdFunc(cmdLookup),
+	})
+	dispatcher.Handle(&Command{
+		Name:          "server-info",
+		Usage:         "*character*",
+		Description:   "do some number crunch and display server-wide stats about a character.",
+		Examples:      []string{"server-info tfp"},
+		Cost:          10,
+		Cooldown:      10 * time.Minute,
+		CooldownScope: ScopeGuild,
+		Handler:       CmdFunc(cmdServerInfo),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "register",
+		Usage:       "*ally-code* *[account]*",
+		Description: "link your ally code to your Discord user.",
+		Details:     "Give the account a name to link more than one. Officers can @mention someone to register for them.",
+		Examples:    []string{"register 123-456-789", "register 987-654-321 alt1"},
+		Handler:     CmdFunc(cmdRegister),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "unregister",
+		Usage:       "*[account]*",
+		Description: "remove your linked ally codes, or just one account.",
+		Examples:    []string{"unregister", "unregister alt1"},
+		Handler:     CmdFunc(cmdUnregister),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "accounts",
+		Usage:       "*[default account]*",
+		Description: "list your linked accounts, or change the default one.",
+		Details:     "Use +account or [account] in any command to pick one of your accounts.",
+		Examples:    []string{"accounts", "accounts default alt1", "stats rey +alt1"},
+		Handler:     CmdFunc(cmdAccounts),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "config",
+		Usage:       "*get|set|reset* *[setting]* *[value]*",
+		Description: "change my settings for this server, like the command prefix.",
+		Details:     "Settings: " + strings.Join(settingKeys, ", ") + ".",
+		Examples:    []string{"config get", "config set prefix !", "config reset prefix"},
+		Perm:        PermAdmin,
+		Handler:     CmdFunc(cmdConfig),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "disable",
+		Usage:       "*[command]* *[message]*",
+		Description: "disable a command in this server, or list the disabled commands.",
+		Details:     "The message is shown to whoever tries the command. Bot owners can use +global to disable it in all servers.",
+		Flags:       []string{"+global"},
+		Examples:    []string{"disable", "disable lookup Use it in the #lookup channel of our other server", "disable lookup +global Fixing stale results"},
+		Perm:        PermAdmin,
+		Handler:     CmdFunc(cmdDisable),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "enable",
+		Usage:       "*command*",
+		Description: "enable a command disabled in this server.",
+		Details:     "Bot owners can use +global to enable a command disabled in all servers.",
+		Flags:       []string{"+global"},
+		Examples:    []string{"enable lookup", "enable lookup +global"},
+		Perm:        PermAdmin,
+		Handler:     CmdFunc(cmdEnable),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "share-this-bot",
+		Description: "if you want my help in a galaxy far, far away...",
+		Handler:     CmdFunc(cmdShareThisBot),
+	})
 
 	// Undocumented on pourpose
-	dispatcher.Handle("guilds-i-am-running", CmdFunc(cmdBotStats))
-	dispatcher.Handle("reload-profiles", CmdFunc(cmdReloadProfiles))
-	dispatcher.Handle("leave-guild", CmdFunc(cmdLeaveGuild))
-	dispatcher.Handle("debug-image", CmdFunc(cmdDebugImage))
+	dispatcher.Handle(&Command{
+		Name:        "bot-stats",
+		Aliases:     []string{"guilds-i-am-running"},
+		Usage:       "*[days]*",
+		Description: "count the servers I am running on, and summarize the command usage.",
+		Examples:    []string{"bot-stats", "bot-stats 30"},
+		Perm:        PermOwner,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdBotStats),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "reload-profiles",
+		Description: "read again the profile links channel.",
+		Flags:       []string{"+verbose", "+v"},
+		Perm:        PermOfficer,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdReloadProfiles),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "leave-guild",
+		Usage:       "*guild-id*",
+		Description: "make me leave a server.",
+		Perm:        PermOwner,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdLeaveGuild),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "register-commands",
+		Usage:       "*[here]*",
+		Description: "register my slash commands, in all servers or just here.",
+		Perm:        PermOwner,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdRegisterCommands),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "log-level",
+		Usage:       "*[debug|info|warn|error]*",
+		Description: "display or change my log level.",
+		Perm:        PermOwner,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdLogLevel),
+	})
+	dispatcher.Handle(&Command{
+		Name:        "debug-image",
+		Usage:       "*character*",
+		Description: "test the character stats drawing.",
+		Perm:        PermOwner,
+		Hidden:      true,
+		Handler:     CmdFunc(cmdDebugImage),
+	})
 }
 
 // main runs the main loop of our bot application.
 func main() {
 	flag.Parse()
-	// Check we have a token
-	if *token == "" {
-		logger.Fatalf("Error initializing bot: missing token")
+	if flag.Arg(0) == "repl" {
+		// Allow flags after the repl argument too
+		flag.CommandLine.Parse(flag.Args()[1:])
+		*console = true
 	}
-	// When using linked docker containers, lookup for pagerender addr
-	renderContainer := os.Getenv("PAGERENDER_PORT_8080_TCP_ADDR")
-	if renderContainer != "" {
-		renderPageHost = fmt.Sprintf("http://%s:8080", renderContainer)
+	if err := loadConfig(); err != nil {
+		logger.Fatalf("Error initializing bot: %v", err)
 	}
-	logger.Printf("Using rendering service at %v", renderPageHost)
-
-	// Start the websocket listener shards
-	shardCount := 3
-	done := make(chan bool)
+	logger.Printf("Using rendering service at %v", conf().PageRenderHost)
+	data, err := NewDataSource(*dataSource, NewAPIClient(*apiUser, *apiPass))
+	if err != nil {
+		logger.Fatalf("Error initializing bot: %v", err)
+	}
+	dispatcher.data = data
 
-	runShard := func(shardID int) {
-		dg, err := discordgo.New("Bot " + *token)
-		if err != nil {
-			logger.Fatalf("Error initializing: %v", err)
+	// Run the commands from stdin, with nothing saved to the database
+	if *console {
+		if err := runConsole(os.Stdin, os.Stdout); err != nil {
+			logger.Fatalf("Error running console: %v", err)
 		}
+		return
+	}
+	// Load the persistent profile links saved from previous runs
+	if *cacheDir == "" {
+		*cacheDir = "."
+	}
+	if store, err = OpenStore(*cacheDir); err != nil {
+		logger.Fatalf("Error opening bot database at %v: %v", *cacheDir, err)
+	}
+	defer store.Close()
+	guilds, err := store.Guilds()
+	if err != nil {
+		logger.Errorf("Error loading saved guilds: %v", err)
+	}
+	for _, g := range guilds {
+		guildCacheFor(g.ID, g.Name)
+	}
+	logger.Printf("Loaded profile links for %d guilds", len(guilds))
+
+	var (
+		httpServer   *http.Server
+		interactions *InteractionServer
+	)
+	if *interactionsAddr != "" {
+		httpServer, interactions = serveInteractions(*interactionsAddr)
+	}
 
-		dg.ShardCount = shardCount
-		dg.ShardID = shardID
-
-		dg.AddHandler(ready)
-		dg.AddHandler(messageCreate)
-
-		err = dg.Open()
-		if err != nil {
-			logger.Fatalf("Error opening websocket: %v", err)
+	// Start the websocket listener shards
+	count := *shards
+	if count <= 0 {
+		if count, err = recommendedShards(*token); err != nil {
+			logger.Errorf("Unable to get the recommended shard count, using one shard: %v", err)
+			count = 1
 		}
-
-		// Wait here until CTRL-C or other term signal is received.
-		logger.Infof("Bot shard %d is now running.  Press CTRL-C to exit.", shardID)
-		sc := make(chan os.Signal, 1)
-		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill, syscall.SIGKILL)
-		<-sc
-		logger.Infof("Trying to close sessions...")
-		dg.Close()
-		done <- true
 	}
-
-	for sid := 0; sid < shardCount; sid++ {
-		logger.Infof("Launching shard ID %v", sid)
-		go runShard(sid)
+	shardManager = NewShardManager(count, discordShards(*token,
+		ready, messageCreate, messageUpdate, messageDelete, messageDeleteBulk))
+	if *statusAddr != "" {
+		go serveStatus(*statusAddr, shardManager)
+	}
+	shardManager.Start()
+	logger.Infof("%v shards are running. Press CTRL-C to exit.", count)
+
+	// Wait here until CTRL-C or other term signal is received,
+	// reloading the config file on SIGHUP.
+	set := commandLineFlags()
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-sc
+	for ; sig == syscall.SIGHUP; sig = <-sc {
+		reloadConfig(set)
 	}
-	logger.Infof("%v shards are running.", shardCount)
-	for i := 0; i < shardCount; i++ {
-		logger.Infof("One more shard terminated.")
-		<-done
+	logger.Infof("Received %v, waiting for the running commands...", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	if httpServer != nil {
+		// Stop receiving slash commands before draining the dispatcher
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.Errorf("Error stopping the interactions server: %v", err)
+		}
+	}
+	if err := dispatcher.Drain(ctx); err != nil {
+		logger.Errorf("Commands still running after %v: %v", *drainTimeout, err)
+	}
+	if interactions != nil {
+		if err := interactions.Wait(ctx); err != nil {
+			logger.Errorf("Interaction replies still pending after %v: %v", *drainTimeout, err)
+		}
 	}
+	cancel()
+	logger.Infof("Trying to close sessions...")
+	shardManager.Close()
 	fmt.Println("All shards exited. Terminating...")
 }
 
@@ -131,7 +368,7 @@ func ready(s *discordgo.Session, event *discordgo.Ready) {
 	} else {
 		logger.Infof("Profile updated: %v", u)
 	}
-	logger.Infof("Guild count %d", listMyGuilds(s))
+	logger.Infof("Guild count %d", listMyGuilds(newSession(s)))
 }
 
 // onGuildJoin handles the event of joining a guild.
@@ -148,29 +385,80 @@ func onGuildJoin(s *discordgo.Session, event *discordgo.GuildCreate) {
 
 // messageCreate handles the Discord event of a new message in a channel.
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if err := dispatcher.Dispatch(s, m); err != nil {
+	defer recoverEvent("message create")
+	err := dispatcher.Dispatch(newSession(s), m)
+	if err == errShuttingDown {
+		logger.Infof("Ignored command while shutting down: %v", m.Content)
+	} else if err != nil {
 		logger.Errorf("unable to handle command: %v", err)
 	}
 }
 
+// messageUpdate handles the Discord event of a message edit,
+// updating the profile link if the message is from #swgoh-gg.
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	defer recoverEvent("message update")
+	// Updates without an author are embed-only (e.g. link previews)
+	if m.Author == nil || m.Author.ID == s.State.User.ID {
+		return
+	}
+	if cache, ok := registryCache(newSession(s), m.ChannelID); ok {
+		cache.ApplyLinkMessage(m.Message)
+	}
+}
+
+// messageDelete handles the Discord event of a message deletion,
+// removing the profile link if the message was from #swgoh-gg.
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	defer recoverEvent("message delete")
+	if cache, ok := registryCache(newSession(s), m.ChannelID); ok {
+		cache.RemoveLinksFromMessages(m.ID)
+	}
+}
+
+// messageDeleteBulk handles the Discord event of several messages deleted at once,
+// removing the profile links created by any of them in #swgoh-gg.
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	defer recoverEvent("message delete bulk")
+	if cache, ok := registryCache(newSession(s), m.ChannelID); ok {
+		cache.RemoveLinksFromMessages(m.Messages...)
+	}
+}
+
+// registryCache returns the guild cache if channelID is the guild registry channel.
+func registryCache(s Session, channelID string) (*Cache, bool) {
+	channel, err := apiCache.GetChannel(s, channelID)
+	if err != nil || channel == nil {
+		logger.Errorf("Unable to load channel %v: %v", channelID, err)
+		return nil, false
+	}
+	if channel.GuildID == "" {
+		return nil, false
+	}
+	guild, err := apiCache.GetGuild(s, channel.GuildID)
+	if err != nil || guild == nil {
+		logger.Errorf("Unable to load guild %v: %v", channel.GuildID, err)
+		return nil, false
+	}
+	cache, _ := guildCacheFor(guild.ID, guild.Name)
+	return cache, cache.Settings().IsRegistryChannel(channel)
+}
+
 // copyrightFooter is a reusable embed footer.
 var copyrightFooter = &discordgo.MessageEmbedFooter{
 	IconURL: "https://swgoh.gg/static/logos/swgohgg-logo-twitter-profile.png",
 	Text:    "(C) https://swgoh.gg/",
 }
 
-// embedColor is the default color for embeds.
-var embedColor = 0x00d1db
-
 // send is a helper function that formats a text message and send to the target channel.
-func send(s *discordgo.Session, channelID, message string, args ...interface{}) (*discordgo.Message, error) {
+func send(s Session, channelID, message string, args ...interface{}) (*discordgo.Message, error) {
 	m, err := s.ChannelMessageSend(channelID, fmt.Sprintf(message, args...))
 	return m, err
 }
 
 // cleanup attempts to delete a posted message, if existent.
 // Used to remove "i am loading stuff", temporary messages the bot issues.
-func cleanup(s *discordgo.Session, m *discordgo.Message) {
+func cleanup(s Session, m *discordgo.Message) {
 	if s == nil || m == nil {
 		logger.Infof("Skipped message clean up (%v, %v)", s, m)
 		return
@@ -182,11 +470,12 @@ func cleanup(s *discordgo.Session, m *discordgo.Message) {
 }
 
 // askForProfile explains to the user how to provide profile information.
-func askForProfile(s *discordgo.Session, m *discordgo.MessageCreate, cmd string) {
+func askForProfile(s Session, m *discordgo.MessageCreate, cmd string, settings GuildSettings) {
 	msg := "%s, not sure if I told you before, but you can setup your" +
-		" profile at #swgoh-gg so I know where to look at. Otherwise, tell" +
-		" me a profile name in [], like: /%s [ronoaldo] ..."
-	send(s, m.ChannelID, msg, m.Author.Mention(), cmd)
+		" profile at %s or with %sregister 123-456-789 so I know where" +
+		" to look at. Otherwise, tell me a profile name in [], like: %s%s [ronoaldo] ..."
+	channel, _ := settings.Get("registry-channel")
+	send(s, m.ChannelID, msg, m.Author.Mention(), channel, settings.Prefix, settings.Prefix, cmd)
 }
 
 // newAttachment creates a new attachment for the provided image, using the specified name.
@@ -200,11 +489,15 @@ func newAttachment(b []byte, name string) []*discordgo.File {
 	}
 }
 
-// prefetch downloads and discards an URL. It is intended to fetch and to let server
-// cache data.
-func download(logger *Logger, url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	logger.Printf("PREF: %s prefetched (resp %v)", url, resp)
+// download fetches an URL and returns the response body. The request is
+// canceled when ctx is done.
+func download(ctx context.Context, logger *Logger, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	logger.Debugf("PREF: %s prefetched (resp %v)", url, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -217,7 +510,7 @@ func download(logger *Logger, url string) ([]byte, error) {
 }
 
 // listMyGuilds list all my guilds currently working on.
-func listMyGuilds(s *discordgo.Session) int {
+func listMyGuilds(s Session) int {
 	logger.Printf("Running listMyGuilds ...")
 	last := ""
 	count := 0
@@ -243,10 +536,13 @@ func listMyGuilds(s *discordgo.Session) int {
 }
 
 // renderImageAt calls the pageRender server and returns the image bytes using download().
-func renderImageAt(logger *Logger, targetURL, querySelector, click, size string) ([]byte, error) {
+func renderImageAt(ctx context.Context, logger *Logger, targetURL, querySelector, click, size string) ([]byte, error) {
+	start := time.Now()
 	renderURL := fmt.Sprintf("%s/pageRender?url=%s&querySelector=%s&clickSelector=%s&size=%s&ts=%d",
-		renderPageHost, esc(targetURL), querySelector, click, size, time.Now().UnixNano())
-	return download(logger, renderURL)
+		conf().PageRenderHost, esc(targetURL), querySelector, click, size, start.UnixNano())
+	b, err := download(ctx, logger, renderURL)
+	upstreamDuration.Since(start, "pagerender", upstreamOutcome(err))
+	return b, err
 }
 
 // logJSON takes a value and serializes it to the log stream  as a JSON
@@ -270,6 +566,33 @@ func asBool(src string) bool {
 	return res
 }
 
+// envOr returns the environment variable, or value if it is empty.
+func envOr(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// defaultPageRenderHost returns the PageRender linked container URL,
+// when using linked docker containers, or the local URL.
+func defaultPageRenderHost() string {
+	if addr := os.Getenv("PAGERENDER_PORT_8080_TCP_ADDR"); addr != "" {
+		return fmt.Sprintf("http://%s:8080", addr)
+	}
+	return "http://localhost:8080"
+}
+
+// asInt is an error-safe parse int function.
+// returns 0 if unable to parse the input as integer.
+func asInt(src string) int {
+	res, err := strconv.Atoi(src)
+	if err != nil {
+		return 0
+	}
+	return res
+}
+
 // esc is a shorthand for url.QueryEscape.
 func esc(src string) string {
 	return url.QueryEscape(src)
@@ -293,14 +616,3 @@ func isAllyCode(src string) (string, bool) {
 	}
 	return src, false
 }
-
-func factionName(src string) string {
-	switch strings.ToLower(src) {
-	case "imperial troopers":
-		return "Imperial Troopers"
-	case "bh", "bounty hunter", "bounty hunters":
-		return "Bounty Hunters"
-	}
-
-	return strings.Title(src)
-}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBuckets are the histogram buckets, in seconds, for the latency metrics.
+var defaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metrics is the registry of the metrics exported at /metrics.
+var metrics = NewRegistry()
+
+var (
+	commandsTotal = metrics.NewCounter("ap5r_commands_total",
+		"Commands handled, by command and outcome.", "command", "outcome")
+	commandDuration = metrics.NewHistogram("ap5r_command_duration_seconds",
+		"Time to handle the commands.", defaultBuckets, "command")
+	upstreamDuration = metrics.NewHistogram("ap5r_upstream_request_duration_seconds",
+		"Latency of the requests to PageRender and api.swgoh.help, by outcome.", defaultBuckets, "service", "outcome")
+	cacheRequests = metrics.NewCounter("ap5r_cache_requests_total",
+		"Cache lookups, by cache and result (hit or miss).", "cache", "result")
+)
+
+func init() {
+	metrics.NewGaugeFunc("ap5r_guild_caches", "Servers with profile links or settings loaded.", nil, func() []Sample {
+		guildCacheMu.Lock()
+		defer guildCacheMu.Unlock()
+		return []Sample{{Value: float64(len(guildCache))}}
+	})
+	metrics.NewGaugeFunc("ap5r_registry_links", "Linked accounts in all servers.", nil, func() []Sample {
+		guildCacheMu.Lock()
+		defer guildCacheMu.Unlock()
+		count := 0
+		for _, c := range guildCache {
+			count += c.LinkCount()
+		}
+		return []Sample{{Value: float64(count)}}
+	})
+	metrics.NewGaugeFunc("ap5r_shard_connected", "Whether the gateway shard is connected.", []string{"shard"}, func() []Sample {
+		return shardSamples(func(s ShardStatus) float64 {
+			if s.State == ShardConnected {
+				return 1
+			}
+			return 0
+		})
+	})
+	metrics.NewGaugeFunc("ap5r_shard_guilds", "Servers handled by the gateway shard.", []string{"shard"}, func() []Sample {
+		return shardSamples(func(s ShardStatus) float64 { return float64(s.Guilds) })
+	})
+	metrics.NewGaugeFunc("ap5r_shard_restarts", "Times the gateway shard was restarted.", []string{"shard"}, func() []Sample {
+		return shardSamples(func(s ShardStatus) float64 { return float64(s.Restarts) })
+	})
+}
+
+// shardSamples returns one sample per shard of the shardManager, with value fn.
+func shardSamples(fn func(ShardStatus) float64) (samples []Sample) {
+	if shardManager == nil {
+		return nil
+	}
+	for _, s := range shardManager.Status() {
+		samples = append(samples, Sample{Labels: []string{strconv.Itoa(s.ID)}, Value: fn(s)})
+	}
+	return samples
+}
+
+// Registry holds the metrics, written in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is a metric family in the registry.
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	list := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range list {
+		m.write(w)
+	}
+}
+
+// NewCounter registers a counter, with the label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	r.add(c)
+	return c
+}
+
+// NewHistogram registers a histogram with the bucket upper bounds, in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.add(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose samples are returned by fn when the metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func() []Sample) {
+	r.add(&gaugeFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+// Sample is a gauge value, with the label values.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Counter is a metric that only goes up, with one value per label values.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Inc adds one to the counter with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(values)] += v
+}
+
+// Value returns the counter with the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelKey(values)]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitLabelKey(key)), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations in buckets, with one set of buckets per label values.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds the value v to the histogram with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the time elapsed since start, in seconds.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns the number of observations with the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[labelKey(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, values := h.series[key], splitLabelKey(key)
+		labels := append(append([]string(nil), h.labels...), "le")
+		bucket := func(le string) []string {
+			return append(append([]string(nil), values...), le)
+		}
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, bucket(formatFloat(le))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, bucket("+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// gaugeFunc is a gauge computed when the metrics are written.
+type gaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, s := range g.fn() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.Labels), formatFloat(s.Value))
+	}
+}
+
+// labelKey joins the label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// splitLabelKey returns the label values of a key from labelKey.
+func splitLabelKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the label names and values, like {command="stats"}.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// commandOutcome returns the metrics outcome label of the handler error.
+func commandOutcome(err error) string {
+	switch err {
+	case nil:
+		return "ok"
+	case errProfileRequered:
+		return "no_profile"
+	case errPermissionDenied:
+		return "denied"
+	case errRateLimited:
+		return "rate_limited"
+	case errTimeout:
+		return "timeout"
+	}
+	return "error"
+}
+
+// upstreamOutcome returns the metrics outcome label of a request error.
+func upstreamOutcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// withMetrics counts the commands by outcome and measures how long they take.
+func withMetrics(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		start := time.Now()
+		err := next.HandleCommand(r)
+		commandsTotal.Inc(r.cmd.Name, commandOutcome(err))
+		commandDuration.Since(start, r.cmd.Name)
+		return err
+	})
+}
+
+// StatusServer serves the bot health at /healthz, and the metrics at /metrics.
+type StatusServer struct {
+	Registry   *Registry
+	Shards     *ShardManager
+	Dispatcher *CmdDispatcher
+}
+
+func (srv *StatusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		srv.Registry.WriteText(w)
+	case "/healthz":
+		srv.serveHealth(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// shardHealth is the shard status in the /healthz response.
+type shardHealth struct {
+	ID       int       `json:"id"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Guilds   int       `json:"guilds"`
+	Restarts int       `json:"restarts"`
+}
+
+// serveHealth responds with the shard states. The bot is unhealthy while
+// shutting down, or if a shard is not connected for longer than the
+// shard manager takes to restart it.
+func (srv *StatusServer) serveHealth(w http.ResponseWriter) {
+	status := "ok"
+	if srv.Dispatcher.Draining() {
+		status = "shutting down"
+	}
+	var shards []shardHealth
+	for _, s := range srv.Shards.Status() {
+		if s.State != ShardConnected && time.Since(s.Since) > 2*srv.Shards.RestartAfter && status == "ok" {
+			status = fmt.Sprintf("shard %d %v", s.ID, s.State)
+		}
+		shards = append(shards, shardHealth{
+			ID:       s.ID,
+			State:    s.State.String(),
+			Since:    s.Since,
+			Guilds:   s.Guilds,
+			Restarts: s.Restarts,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "shards": shards})
+}
+
+// serveStatus listens for the /healthz and /metrics requests at addr.
+func serveStatus(addr string, manager *ShardManager) {
+	logger.Printf("Listening for health checks and metrics at %v", addr)
+	srv := &StatusServer{Registry: metrics, Shards: manager, Dispatcher: dispatcher}
+	if err := http.ListenAndServe(addr, srv); err != nil {
+		logger.Fatalf("Error listening for metrics: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "name")
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{0.5, 1}, "name")
+	r.NewGaugeFunc("test_gauge", "Test gauge.", nil, func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+	c.Inc(`a "quoted" name`)
+	c.Add(2, "b")
+	h.Observe(0.25, "a")
+	h.Observe(0.75, "a")
+	h.Observe(3, "a")
+
+	var b bytes.Buffer
+	r.WriteText(&b)
+	for _, expected := range []string{
+		"# HELP test_total Test counter.\n# TYPE test_total counter\n",
+		`test_total{name="a \"quoted\" name"} 1` + "\n",
+		`test_total{name="b"} 2` + "\n",
+		"# TYPE test_seconds histogram\n",
+		`test_seconds_bucket{name="a",le="0.5"} 1` + "\n",
+		`test_seconds_bucket{name="a",le="1"} 2` + "\n",
+		`test_seconds_bucket{name="a",le="+Inf"} 3` + "\n",
+		`test_seconds_sum{name="a"} 4` + "\n",
+		`test_seconds_count{name="a"} 3` + "\n",
+		"# TYPE test_gauge gauge\ntest_gauge 42\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Metrics missing %q:\n%s", expected, b.String())
+		}
+	}
+}
+
+func TestCommandMetrics(t *testing.T) {
+	h := newTestHarness(t)
+	ok, denied := commandsTotal.Value("help", "ok"), commandsTotal.Value("config", "denied")
+	count := commandDuration.Count("help")
+	h.send("user", "/help")
+	h.send("user", "/config get")
+	if v := commandsTotal.Value("help", "ok"); v != ok+1 {
+		t.Errorf("Unexpected help count: %v, expected %v", v, ok+1)
+	}
+	if v := commandsTotal.Value("config", "denied"); v != denied+1 {
+		t.Errorf("Unexpected denied config count: %v, expected %v", v, denied+1)
+	}
+	if c := commandDuration.Count("help"); c != count+1 {
+		t.Errorf("Unexpected help duration count: %v, expected %v", c, count+1)
+	}
+}
+
+func TestStatusServer(t *testing.T) {
+	var events func(*discordgo.Session, interface{})
+	m := NewShardManager(1, func(id, count int, e func(*discordgo.Session, interface{})) (io.Closer, error) {
+		events = e
+		return &fakeShardSession{}, nil
+	})
+	m.CheckInterval = time.Hour
+	m.RestartAfter = 0
+	m.Start()
+	defer m.Close()
+	srv := httptest.NewServer(&StatusServer{Registry: metrics, Shards: m, Dispatcher: NewDispatcher()})
+	defer srv.Close()
+
+	health := func() (int, map[string]interface{}) {
+		resp, err := http.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+	if code, body := health(); code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected health before connecting: %v %v", code, body)
+	}
+	events(nil, &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "guild"}}})
+	code, body := health()
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("Unexpected health when connected: %v %v", code, body)
+	}
+	if shards, _ := body["shards"].([]interface{}); len(shards) != 1 {
+		t.Errorf("Unexpected shards: %v", body["shards"])
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	var b bytes.Buffer
+	b.ReadFrom(resp.Body)
+	for _, expected := range []string{"# TYPE ap5r_commands_total counter", "ap5r_guild_caches "} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Metrics missing %q:\n%s", expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Middleware wraps a CmdHandler to add behavior before and after it is called.
+type Middleware func(CmdHandler) CmdHandler
+
+// defaultMiddlewares are installed in all dispatchers by NewDispatcher.
+var defaultMiddlewares = []Middleware{
+	withLogging,
+	withReactions,
+	withMetrics,
+	withUsage,
+	withRecovery,
+	withPermission,
+	withDisabled,
+	withRateLimit,
+	withTimeout,
+	withProfile,
+}
+
+// Chain wraps h with the middlewares, so the first middleware
+// is the first one to be called.
+func Chain(h CmdHandler, mw ...Middleware) CmdHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// withLogging logs the received command and how long it took to handle it.
+func withLogging(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		r.l.Printf("RECV: (#%v) %v: %v", r.channel.Name, r.m.Author, r.m.Content)
+		start := time.Now()
+		err := next.HandleCommand(r)
+		if err != nil {
+			r.l.Errorf("DONE: %v failed after %v: %v", r.cmd.Name, time.Since(start), err)
+		} else {
+			r.l.Printf("DONE: %v took %v", r.cmd.Name, time.Since(start))
+		}
+		return err
+	})
+}
+
+// withReactions reacts to the command message while it is being handled
+// and with the command result.
+func withReactions(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiClock)
+		err := next.HandleCommand(r)
+		switch err {
+		case nil:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiCheckMark)
+		case errProfileRequered:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiFacePalm)
+			askForProfile(r.s, r.m, r.args.Command, r.settings)
+			return nil
+		case errPermissionDenied:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiNoEntry)
+			return nil
+		case errTimeout:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiHourGlassDone)
+		case errRateLimited:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiStopSign)
+			return nil
+		default:
+			r.s.MessageReactionAdd(r.m.ChannelID, r.m.ID, emojiCrossMark)
+		}
+		return err
+	})
+}
+
+// withPermission refuses the command if the user does not have
+// the permission level required by it.
+func withPermission(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		if !r.HasPermission(r.cmd.Perm) {
+			r.l.Printf("DENY: %v is not %v", r.m.Author, r.cmd.Perm)
+			send(r.s, r.m.ChannelID, "Sorry %s, only %s can use **%s**.", r.m.Author.Mention(), r.cmd.Perm, r.args.Command)
+			return errPermissionDenied
+		}
+		return next.HandleCommand(r)
+	})
+}
+
+// withDisabled replies with the disabled message instead of running the command,
+// when it was disabled in all servers or in this one.
+func withDisabled(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		if msg, disabled := commandDisabled(r.settings, r.cmd, r.args.Command); disabled {
+			_, err := send(r.s, r.m.ChannelID, "%s", msg)
+			return err
+		}
+		return next.HandleCommand(r)
+	})
+}
+
+// withRateLimit refuses the command if the user, channel or server quotas are
+// exhausted, or if the command is in cooldown. Bot owners are not limited.
+func withRateLimit(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		if isOwner(r.m.Author.ID) {
+			return next.HandleCommand(r)
+		}
+		if wait := rateLimiter.Allow(commandLimits(r)...); wait > 0 {
+			r.l.Printf("LIMIT: %v must wait %v to use %v", r.m.Author, wait, r.cmd.Name)
+			send(r.s, r.m.ChannelID, "Easy there %s, my circuits need some rest! Try again in %d seconds.",
+				r.m.Author.Mention(), int(math.Ceil(wait.Seconds())))
+			return errRateLimited
+		}
+		return next.HandleCommand(r)
+	})
+}
+
+// withTimeout sets the request context deadline to the command timeout,
+// and tells the user when the command takes too long.
+func withTimeout(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		timeout := r.cmd.Timeout
+		if timeout <= 0 {
+			timeout = conf().CmdTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.ctx, timeout)
+		defer cancel()
+		r.ctx = ctx
+		err := next.HandleCommand(r)
+		if ctx.Err() == context.DeadlineExceeded {
+			r.l.Errorf("TIME: %v timed out after %v: %v", r.cmd.Name, timeout, err)
+			send(r.s, r.m.ChannelID, "Sorry %s, this is taking too long and I gave up. Please try again later.", r.m.Author.Mention())
+			return errTimeout
+		}
+		return err
+	})
+}
+
+// withProfile resolves the ally code of the request: from the selected account,
+// from the [profile] argument, or from the linked account of the author
+// or of the mentioned user.
+func withProfile(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		// Profiles are from the author, or from the mentioned user
+		discordUserID := r.m.Author.ID
+		if len(r.m.Mentions) > 0 {
+			discordUserID = r.m.Mentions[0].ID
+		}
+		// If the user selected one of the linked accounts, use it
+		if label := r.args.Account(r.cache.AccountLabels(discordUserID)); label != "" {
+			r.allyCode, r.allyCodeOk = r.cache.ResolveAllyCode(r.ctx, r.data, discordUserID, label)
+		} else if r.args.Profile != "" {
+			// User passed explicitly. Check if it is ally code or not
+			if allyCodeRe.MatchString(r.args.Profile) {
+				// Use the provided ally code
+				r.allyCode = r.args.Profile
+				r.allyCodeOk = true
+			} else {
+				// Lookup the ally code from the profile
+				allyCode, err := r.data.AllyCode(r.ctx, r.args.Profile)
+				if err != nil {
+					r.l.Errorf("Unable to lookup ally code for %v: %v", r.args.Profile, err)
+				}
+				r.allyCode, r.allyCodeOk = allyCode, err == nil
+			}
+		} else {
+			// User passed implicitly. Check if we had discovered ally code yet
+			r.allyCode, r.allyCodeOk = r.cache.ResolveAllyCode(r.ctx, r.data, discordUserID, "")
+		}
+		return next.HandleCommand(r)
+	})
+}
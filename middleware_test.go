@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next CmdHandler) CmdHandler {
+			return CmdFunc(func(r CmdRequest) error {
+				calls = append(calls, name)
+				return next.HandleCommand(r)
+			})
+		}
+	}
+	h := CmdFunc(func(r CmdRequest) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	if err := Chain(h, mw("first"), mw("second")).HandleCommand(CmdRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"first", "second", "handler"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Unexpected call order: %v, expected %v", calls, expected)
+	}
+}
+
+func TestWithProfile(t *testing.T) {
+	cache := NewCache(nil, "guild", "Guild")
+	cache.SetLink(&ProfileLink{UserID: "author", AllyCode: "111-111-111"})
+	cache.SetLink(&ProfileLink{UserID: "author", Label: "alt1", AllyCode: "222-222-222"})
+	cache.SetLink(&ProfileLink{UserID: "old", Profile: "oldprofile"})
+	data := NewMemoryDataSource()
+	data.AllyCodes["oldprofile"] = "444444444"
+	data.AllyCodes["someone"] = "555555555"
+
+	testCases := []struct {
+		author   string
+		line     string
+		allyCode string
+	}{
+		{author: "author", line: "/stats rey", allyCode: "111-111-111"},
+		{author: "author", line: "/stats rey +alt1", allyCode: "222-222-222"},
+		{author: "author", line: "/stats rey [333-333-333]", allyCode: "333-333-333"},
+		{author: "author", line: "/stats rey [someone]", allyCode: "555555555"},
+		{author: "old", line: "/stats rey", allyCode: "444444444"},
+		{author: "nobody", line: "/stats rey", allyCode: ""},
+	}
+	for i, tc := range testCases {
+		r := CmdRequest{
+			ctx:   context.Background(),
+			m:     &discordgo.MessageCreate{Message: &discordgo.Message{Author: &discordgo.User{ID: tc.author}}},
+			l:     logger,
+			cache: cache,
+			data:  data,
+			args:  ParseArgs(tc.line),
+		}
+		var allyCode string
+		h := withProfile(CmdFunc(func(r CmdRequest) error {
+			allyCode = r.allyCode
+			return nil
+		}))
+		h.HandleCommand(r)
+		if allyCode != tc.allyCode {
+			t.Errorf("Test case #%d: unexpected ally code for %s: %v, expected %v", i, tc.line, allyCode, tc.allyCode)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	r := CmdRequest{
+		ctx: context.Background(),
+		cmd: &Command{Name: "stats", Timeout: time.Minute},
+	}
+	h := withTimeout(CmdFunc(func(r CmdRequest) error {
+		deadline, ok := r.ctx.Deadline()
+		if !ok || time.Until(deadline) > time.Minute {
+			t.Errorf("Unexpected request deadline: %v (ok=%v)", deadline, ok)
+		}
+		return nil
+	}))
+	if err := h.HandleCommand(r); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
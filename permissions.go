@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Permission is the level required to run a command.
+// Each level includes all the levels bellow it.
+type Permission int
+
+// Available permission levels.
+const (
+	// PermEveryone allows anyone in the server to run the command.
+	PermEveryone Permission = iota
+	// PermOfficer requires one of the guild officer roles, see /config officer-roles.
+	PermOfficer
+	// PermAdmin requires the Administrator or Manage Server Discord permissions.
+	PermAdmin
+	// PermOwner requires the user to be one of the bot owners, see -owners.
+	PermOwner
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermEveryone:
+		return "everyone"
+	case PermOfficer:
+		return "a guild officer"
+	case PermAdmin:
+		return "a server admin"
+	case PermOwner:
+		return "the bot owner"
+	}
+	return "unknown"
+}
+
+// isOwner returns true if the user ID is in the configured bot owners list.
+func isOwner(userID string) bool {
+	for _, id := range conf().Owners {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// isServerAdmin returns true if the user can manage the server of the channel.
+func isServerAdmin(s Session, userID, channelID string) bool {
+	perms, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		logger.Errorf("Unable to check permissions for %v: %v", userID, err)
+		return false
+	}
+	return perms&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0
+}
+
+// isOfficer returns true if the user has one of the officer roles of the guild.
+func isOfficer(s Session, guild *discordgo.Guild, settings GuildSettings, userID string) bool {
+	if len(settings.OfficerRoles) == 0 {
+		return false
+	}
+	member, err := s.GuildMember(guild.ID, userID)
+	if err != nil {
+		logger.Errorf("Unable to load member %v: %v", userID, err)
+		return false
+	}
+	for _, roleID := range member.Roles {
+		for _, r := range guild.Roles {
+			if r.ID != roleID {
+				continue
+			}
+			for _, officer := range settings.OfficerRoles {
+				if officer == r.ID || strings.EqualFold(officer, r.Name) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// userPermission returns true if the user has at least the permission level
+// perm on the channel. Checks are done from the cheapest to the most expensive.
+func userPermission(s Session, guild *discordgo.Guild, channelID string, settings GuildSettings, userID string, perm Permission) bool {
+	switch {
+	case perm <= PermEveryone:
+		return true
+	case isOwner(userID):
+		return true
+	case perm == PermOwner:
+		return false
+	case isServerAdmin(s, userID, channelID):
+		return true
+	case perm == PermAdmin:
+		return false
+	}
+	return isOfficer(s, guild, settings, userID)
+}
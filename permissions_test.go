@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestUserPermission(t *testing.T) {
+	setTestConfig(t, func(c *Config) { c.Owners = []string{"100"} })
+	s := NewFakeSession()
+	guild := &discordgo.Guild{ID: "perm-guild", Roles: []*discordgo.Role{
+		{ID: "42", Name: "Officers"},
+		{ID: "43", Name: "Members"},
+		{ID: "44", Name: "Council"},
+	}}
+	s.AddGuild(guild, &discordgo.Channel{ID: "perm-channel"})
+	members := []struct {
+		id    string
+		roles []string
+		perms int
+	}{
+		{id: "100"},
+		{id: "root", perms: discordgo.PermissionAdministrator},
+		{id: "admin", perms: discordgo.PermissionManageServer},
+		{id: "officer-by-id", roles: []string{"42"}},
+		{id: "officer-by-name", roles: []string{"43", "44"}},
+		{id: "member", roles: []string{"43"}},
+	}
+	for _, m := range members {
+		s.AddMember(guild.ID, &discordgo.Member{User: &discordgo.User{ID: m.id}, Roles: m.roles}, m.perms)
+	}
+	var settings GuildSettings
+	if err := settings.Set("officer-roles", "<@&42> @council"); err != nil {
+		t.Fatal(err)
+	}
+
+	levels := []Permission{PermEveryone, PermOfficer, PermAdmin, PermOwner}
+	testCases := []struct {
+		userID string
+		// max is the highest level the user has
+		max Permission
+	}{
+		{userID: "100", max: PermOwner},
+		{userID: "root", max: PermAdmin},
+		{userID: "admin", max: PermAdmin},
+		{userID: "officer-by-id", max: PermOfficer},
+		{userID: "officer-by-name", max: PermOfficer},
+		{userID: "member", max: PermEveryone},
+		{userID: "stranger", max: PermEveryone},
+	}
+	for _, tc := range testCases {
+		for _, perm := range levels {
+			expected := perm <= tc.max
+			if got := userPermission(s, guild, "perm-channel", settings, tc.userID, perm); got != expected {
+				t.Errorf("Unexpected permission for %v as %v: %v, expected %v", tc.userID, perm, got, expected)
+			}
+		}
+	}
+
+	// Without officer roles, nobody is an officer
+	if userPermission(s, guild, "perm-channel", GuildSettings{}, "officer-by-id", PermOfficer) {
+		t.Errorf("Unexpected officer without officer roles")
+	}
+}
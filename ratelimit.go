@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Scope is the set of messages that share a rate limit bucket.
+type Scope int
+
+// Available rate limit scopes.
+const (
+	// ScopeUser limits each user independently.
+	ScopeUser Scope = iota
+	// ScopeChannel limits all users in a channel.
+	ScopeChannel
+	// ScopeGuild limits all users in a server.
+	ScopeGuild
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeUser:
+		return "user"
+	case ScopeChannel:
+		return "channel"
+	case ScopeGuild:
+		return "server"
+	}
+	return "unknown"
+}
+
+// Quota is a token bucket configuration: the bucket holds up to Burst tokens,
+// and one token is added back at Every interval.
+type Quota struct {
+	Burst int
+	Every time.Duration
+}
+
+// quotas are the command quotas applied to all commands. Each command
+// takes Command.Cost tokens from the user, channel and server buckets.
+var quotas = map[Scope]Quota{
+	ScopeUser:    {Burst: 10, Every: 6 * time.Second},
+	ScopeChannel: {Burst: 20, Every: 3 * time.Second},
+	ScopeGuild:   {Burst: 40, Every: 1500 * time.Millisecond},
+}
+
+// limit is a request to take cost tokens from the bucket key.
+type limit struct {
+	key   string
+	quota Quota
+	cost  int
+}
+
+// bucket is a token bucket. Tokens are refilled lazily, when the bucket is used.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	quota  Quota
+}
+
+// refill adds the tokens accumulated since the last time the bucket was used.
+func (b *bucket) refill(now time.Time) {
+	b.tokens += float64(now.Sub(b.last)) / float64(b.quota.Every)
+	b.tokens = math.Min(b.tokens, float64(b.quota.Burst))
+	b.last = now
+}
+
+// wait returns how long it will take to have cost tokens in the bucket.
+func (b *bucket) wait(cost int) time.Duration {
+	missing := float64(cost) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing * float64(b.quota.Every)))
+}
+
+// RateLimiter keeps token buckets in memory, by key.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter initializes an empty rate limiter.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes the tokens from all the limits if all of them have enough tokens,
+// and returns zero. Otherwise no token is taken, and the time to wait before
+// trying again is returned.
+func (rl *RateLimiter) Allow(limits ...limit) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	rl.prune(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, len(limits))
+	for i, l := range limits {
+		b, ok := rl.buckets[l.key]
+		if !ok || b.quota != l.quota {
+			b = &bucket{tokens: float64(l.quota.Burst), last: now, quota: l.quota}
+			rl.buckets[l.key] = b
+		}
+		b.refill(now)
+		if w := b.wait(l.cost); w > wait {
+			wait = w
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return wait
+	}
+	for i, b := range buckets {
+		b.tokens -= float64(limits[i].cost)
+	}
+	return 0
+}
+
+// prune removes the full buckets from memory, at most once every 10 minutes.
+func (rl *RateLimiter) prune(now time.Time) {
+	if now.Sub(rl.pruned) < 10*time.Minute {
+		return
+	}
+	rl.pruned = now
+	for key, b := range rl.buckets {
+		if b.refill(now); b.tokens >= float64(b.quota.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// commandLimits returns the limits to apply to the request: the cost of the command
+// from the user, channel and server quotas, and the command cooldown, if any.
+func commandLimits(r CmdRequest) []limit {
+	ids := map[Scope]string{
+		ScopeUser:    r.m.Author.ID,
+		ScopeChannel: r.m.ChannelID,
+		ScopeGuild:   r.guild.ID,
+	}
+	var limits []limit
+	for _, scope := range []Scope{ScopeUser, ScopeChannel, ScopeGuild} {
+		q := quotas[scope]
+		cost := r.cmd.Cost
+		if cost <= 0 {
+			cost = 1
+		}
+		if cost > q.Burst {
+			cost = q.Burst
+		}
+		limits = append(limits, limit{key: fmt.Sprintf("%v:%s", scope, ids[scope]), quota: q, cost: cost})
+	}
+	if r.cmd.Cooldown > 0 {
+		key := fmt.Sprintf("%s:%v:%s", r.cmd.Name, r.cmd.CooldownScope, ids[r.cmd.CooldownScope])
+		limits = append(limits, limit{key: key, quota: Quota{Burst: 1, Every: r.cmd.Cooldown}, cost: 1})
+	}
+	return limits
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+
+	user := limit{key: "user:1", quota: Quota{Burst: 2, Every: 10 * time.Second}, cost: 1}
+	guild := limit{key: "server:1", quota: Quota{Burst: 3, Every: time.Minute}, cost: 1}
+
+	for i := 0; i < 2; i++ {
+		if wait := rl.Allow(user, guild); wait != 0 {
+			t.Fatalf("Request #%d: unexpected wait %v", i, wait)
+		}
+	}
+	if wait := rl.Allow(user, guild); wait != 10*time.Second {
+		t.Errorf("Unexpected wait for empty user bucket: %v", wait)
+	}
+	// A refused request must not take tokens from the other buckets
+	now = now.Add(10 * time.Second)
+	if wait := rl.Allow(user, guild); wait != 0 {
+		t.Errorf("Unexpected wait after refill: %v", wait)
+	}
+	if wait := rl.Allow(user, guild); wait != 50*time.Second {
+		t.Errorf("Unexpected wait for empty guild bucket: %v", wait)
+	}
+
+	// Expensive commands take more tokens
+	other := limit{key: "user:2", quota: Quota{Burst: 10, Every: time.Second}, cost: 10}
+	if wait := rl.Allow(other); wait != 0 {
+		t.Errorf("Unexpected wait for full bucket: %v", wait)
+	}
+	if wait := rl.Allow(other); wait != 10*time.Second {
+		t.Errorf("Unexpected wait for expensive command: %v", wait)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is the subset of the Discord API used by the bot commands.
+// It is implemented by the Discord session returned by newSession,
+// and by FakeSession for tests and for the console mode.
+type Session interface {
+	// BotUser returns the user the bot is logged in as.
+	BotUser() *discordgo.User
+
+	ChannelMessageSend(channelID, content string) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) ([]*discordgo.Message, error)
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+
+	Channel(channelID string) (*discordgo.Channel, error)
+	Guild(guildID string) (*discordgo.Guild, error)
+	GuildChannels(guildID string) ([]*discordgo.Channel, error)
+	GuildMember(guildID, userID string) (*discordgo.Member, error)
+	GuildLeave(guildID string) error
+
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string) (int, error)
+	UserGuilds(limit int, beforeID, afterID string) ([]*discordgo.UserGuild, error)
+}
+
+// discordSession adapts a *discordgo.Session to the Session interface.
+type discordSession struct {
+	*discordgo.Session
+}
+
+// newSession wraps the Discord session s.
+func newSession(s *discordgo.Session) Session {
+	return discordSession{s}
+}
+
+func (s discordSession) BotUser() *discordgo.User {
+	return s.State.User
+}
+
+// GuildMember returns the member from the session state, or from the API
+// if the member is not in the state.
+func (s discordSession) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	if member, err := s.State.Member(guildID, userID); err == nil {
+		return member, nil
+	}
+	return s.Session.GuildMember(guildID, userID)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultRegistryChannel is the channel name where players post their profile links,
+// unless changed with -registry-channel.
+const defaultRegistryChannel = "swgoh-gg"
+
+// languages are the game languages supported by api.swgoh.help.
+var languages = []string{
+	"eng_us", "chs_cn", "cht_cn", "fre_fr", "ger_de", "ind_id", "ita_it",
+	"jpn_jp", "kor_kr", "por_br", "rus_ru", "spa_xm", "tha_th", "tur_tr",
+}
+
+// GuildSettings are the per-server bot settings, editable with /config.
+// Zero values mean the bot defaults are used, see Settings(). EmbedColor
+// is a pointer, so black (0) can be told apart from unset.
+type GuildSettings struct {
+	Prefix           string   `json:"prefix,omitempty"`
+	RegistryChannel  string   `json:"registryChannel,omitempty"`
+	BotChannels      []string `json:"botChannels,omitempty"`
+	OfficerRoles     []string `json:"officerRoles,omitempty"`
+	Language         string   `json:"language,omitempty"`
+	EmbedColor       *int     `json:"embedColor,omitempty"`
+	DisabledCommands []string `json:"disabledCommands,omitempty"`
+	// DisabledMessages are the optional messages explaining why a command was disabled.
+	DisabledMessages map[string]string `json:"disabledMessages,omitempty"`
+}
+
+// settingKeys are the names of the settings, in display order.
+var settingKeys = []string{"prefix", "registry-channel", "bot-channels", "officer-roles", "language", "embed-color", "disabled-commands"}
+
+// WithDefaults returns a copy of the settings with all empty values
+// replaced by the bot defaults.
+func (g GuildSettings) WithDefaults() GuildSettings {
+	c := conf()
+	if g.Prefix == "" {
+		g.Prefix = c.Prefix
+	}
+	if g.RegistryChannel == "" {
+		g.RegistryChannel = c.RegistryChannel
+	}
+	if g.Language == "" {
+		g.Language = languages[0]
+	}
+	if g.EmbedColor == nil {
+		color := c.EmbedColor
+		g.EmbedColor = &color
+	}
+	return g
+}
+
+// Color returns the embed color, or the bot default if unset.
+func (g GuildSettings) Color() int {
+	return *g.WithDefaults().EmbedColor
+}
+
+// IsRegistryChannel returns true if the channel is the one used for profile links.
+func (g GuildSettings) IsRegistryChannel(channel *discordgo.Channel) bool {
+	return channelMatches(channel, g.WithDefaults().RegistryChannel)
+}
+
+// IsBotChannel returns true if the bot can answer commands on the channel.
+// All channels are allowed if no bot channel is configured.
+func (g GuildSettings) IsBotChannel(channel *discordgo.Channel) bool {
+	if len(g.BotChannels) == 0 {
+		return true
+	}
+	for _, c := range g.BotChannels {
+		if channelMatches(channel, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisabled returns true if the command was disabled in this server.
+func (g GuildSettings) IsDisabled(cmd string) bool {
+	for _, c := range g.DisabledCommands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// DisabledMessage returns the message explaining why the command was disabled,
+// and true if the command was disabled in this server.
+func (g GuildSettings) DisabledMessage(cmd string) (string, bool) {
+	if !g.IsDisabled(cmd) {
+		return "", false
+	}
+	return g.DisabledMessages[cmd], true
+}
+
+// Disable disables the command in this server, with an optional message.
+func (g *GuildSettings) Disable(cmd, message string) {
+	cmds := append([]string{}, g.DisabledCommands...)
+	if !g.IsDisabled(cmd) {
+		cmds = append(cmds, cmd)
+		sort.Strings(cmds)
+	}
+	messages := make(map[string]string)
+	for c, m := range g.DisabledMessages {
+		if c != cmd {
+			messages[c] = m
+		}
+	}
+	if message != "" {
+		messages[cmd] = message
+	}
+	g.setDisabled(cmds, messages)
+}
+
+// Enable enables the command disabled in this server.
+func (g *GuildSettings) Enable(cmd string) {
+	var cmds []string
+	for _, c := range g.DisabledCommands {
+		if c != cmd {
+			cmds = append(cmds, c)
+		}
+	}
+	g.setDisabled(cmds, g.DisabledMessages)
+}
+
+// setDisabled changes the disabled commands, keeping only their messages.
+// The messages are copied, as copies of the settings share the map.
+func (g *GuildSettings) setDisabled(cmds []string, messages map[string]string) {
+	g.DisabledCommands, g.DisabledMessages = cmds, nil
+	for _, c := range cmds {
+		if m, ok := messages[c]; ok {
+			if g.DisabledMessages == nil {
+				g.DisabledMessages = make(map[string]string)
+			}
+			g.DisabledMessages[c] = m
+		}
+	}
+}
+
+// Get returns the formatted value of the setting key.
+func (g GuildSettings) Get(key string) (string, error) {
+	g = g.WithDefaults()
+	switch key {
+	case "prefix":
+		return g.Prefix, nil
+	case "registry-channel":
+		return formatChannel(g.RegistryChannel), nil
+	case "bot-channels":
+		if len(g.BotChannels) == 0 {
+			return "(all channels)", nil
+		}
+		var channels []string
+		for _, c := range g.BotChannels {
+			channels = append(channels, formatChannel(c))
+		}
+		return strings.Join(channels, ", "), nil
+	case "officer-roles":
+		if len(g.OfficerRoles) == 0 {
+			return "(none)", nil
+		}
+		var roles []string
+		for _, r := range g.OfficerRoles {
+			roles = append(roles, formatRole(r))
+		}
+		return strings.Join(roles, ", "), nil
+	case "language":
+		return g.Language, nil
+	case "embed-color":
+		return fmt.Sprintf("#%06x", *g.EmbedColor), nil
+	case "disabled-commands":
+		if len(g.DisabledCommands) == 0 {
+			return "(none)", nil
+		}
+		var cmds []string
+		for _, c := range g.DisabledCommands {
+			if m, ok := g.DisabledMessages[c]; ok {
+				c = fmt.Sprintf("%s (%q)", c, m)
+			}
+			cmds = append(cmds, c)
+		}
+		return strings.Join(cmds, ", "), nil
+	}
+	return "", fmt.Errorf("unknown setting %s", key)
+}
+
+// Set parses and changes the value of the setting key.
+func (g *GuildSettings) Set(key, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("missing value for %s", key)
+	}
+	switch key {
+	case "prefix":
+		if strings.ContainsAny(value, " \t") || len(value) > 5 {
+			return fmt.Errorf("prefix must be a single word with at most 5 characters")
+		}
+		g.Prefix = value
+	case "registry-channel":
+		g.RegistryChannel = parseChannel(value)
+	case "bot-channels":
+		g.BotChannels = nil
+		for _, c := range splitList(value) {
+			g.BotChannels = append(g.BotChannels, parseChannel(c))
+		}
+	case "officer-roles":
+		g.OfficerRoles = nil
+		for _, r := range splitList(value) {
+			g.OfficerRoles = append(g.OfficerRoles, parseRole(r))
+		}
+	case "language":
+		value = strings.ToLower(value)
+		for _, l := range languages {
+			if l == value {
+				g.Language = value
+				return nil
+			}
+		}
+		return fmt.Errorf("language must be one of %s", strings.Join(languages, ", "))
+	case "embed-color":
+		color, err := parseColor(value)
+		if err != nil {
+			return err
+		}
+		g.EmbedColor = &color
+	case "disabled-commands":
+		var cmds []string
+		seen := make(map[string]bool)
+		for _, c := range splitList(value) {
+			cmd, ok := dispatcher.Command(strings.ToLower(c))
+			if !ok {
+				return fmt.Errorf("unknown command %s", c)
+			}
+			if alwaysEnabled[cmd.Name] {
+				return fmt.Errorf("the %s command can't be disabled", cmd.Name)
+			}
+			if !seen[cmd.Name] {
+				seen[cmd.Name] = true
+				cmds = append(cmds, cmd.Name)
+			}
+		}
+		sort.Strings(cmds)
+		g.setDisabled(cmds, g.DisabledMessages)
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return nil
+}
+
+// Reset changes the setting key back to the bot default.
+func (g *GuildSettings) Reset(key string) error {
+	switch key {
+	case "prefix":
+		g.Prefix = ""
+	case "registry-channel":
+		g.RegistryChannel = ""
+	case "bot-channels":
+		g.BotChannels = nil
+	case "officer-roles":
+		g.OfficerRoles = nil
+	case "language":
+		g.Language = ""
+	case "embed-color":
+		g.EmbedColor = nil
+	case "disabled-commands":
+		g.DisabledCommands, g.DisabledMessages = nil, nil
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return nil
+}
+
+type languageKey struct{}
+
+// withLanguage returns a copy of ctx that carries the game language,
+// used by the data sources to load the localized unit names.
+func withLanguage(ctx context.Context, language string) context.Context {
+	return context.WithValue(ctx, languageKey{}, language)
+}
+
+// contextLanguage returns the game language in ctx, or an empty
+// string for the API default.
+func contextLanguage(ctx context.Context) string {
+	language, _ := ctx.Value(languageKey{}).(string)
+	return language
+}
+
+var channelMentionRe = regexp.MustCompile("^<#([0-9]+)>$")
+
+// parseChannel converts a channel mention or #name into the value saved in settings:
+// the channel ID for mentions, and the channel name otherwise.
+func parseChannel(src string) string {
+	if m := channelMentionRe.FindStringSubmatch(src); m != nil {
+		return m[1]
+	}
+	return strings.TrimPrefix(src, "#")
+}
+
+// formatChannel formats a saved channel ID or name for display.
+func formatChannel(c string) string {
+	if nonDigits.MatchString(c) {
+		return "#" + c
+	}
+	return "<#" + c + ">"
+}
+
+var roleMentionRe = regexp.MustCompile("^<@&([0-9]+)>$")
+
+// parseRole converts a role mention or name into the value saved in settings:
+// the role ID for mentions, and the role name otherwise.
+func parseRole(src string) string {
+	if m := roleMentionRe.FindStringSubmatch(src); m != nil {
+		return m[1]
+	}
+	return strings.TrimPrefix(src, "@")
+}
+
+// formatRole formats a saved role ID or name for display.
+func formatRole(r string) string {
+	if nonDigits.MatchString(r) {
+		return "@" + r
+	}
+	return "<@&" + r + ">"
+}
+
+// channelMatches returns true if the channel has the saved ID or name.
+func channelMatches(channel *discordgo.Channel, c string) bool {
+	return channel.ID == c || channel.Name == c
+}
+
+// parseColor parses an hex color like #00d1db.
+func parseColor(value string) (int, error) {
+	color, err := strconv.ParseInt(strings.TrimPrefix(value, "#"), 16, 32)
+	if err != nil || color < 0 || color > 0xffffff {
+		return 0, fmt.Errorf("embed color must be an hex color like #00d1db")
+	}
+	return int(color), nil
+}
+
+// splitList splits a comma or space separated list of values.
+func splitList(src string) []string {
+	return strings.FieldsFunc(src, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestGuildSettings(t *testing.T) {
+	var g GuildSettings
+	testCases := []struct {
+		key, value string
+		ok         bool
+		out        string
+	}{
+		{key: "prefix", value: "!", ok: true, out: "!"},
+		{key: "prefix", value: "two words", ok: false},
+		{key: "registry-channel", value: "#profiles", ok: true, out: "#profiles"},
+		{key: "registry-channel", value: "<#12345>", ok: true, out: "<#12345>"},
+		{key: "bot-channels", value: "#bots, <#777>", ok: true, out: "#bots, <#777>"},
+		{key: "officer-roles", value: "<@&42> @Officers", ok: true, out: "<@&42>, @Officers"},
+		{key: "language", value: "POR_BR", ok: true, out: "por_br"},
+		{key: "language", value: "klingon", ok: false},
+		{key: "embed-color", value: "#ff0000", ok: true, out: "#ff0000"},
+		{key: "embed-color", value: "red", ok: false},
+		{key: "embed-color", value: "#000000", ok: true, out: "#000000"},
+		{key: "disabled-commands", value: "info stats", ok: true, out: "stats"},
+		{key: "disabled-commands", value: "lookup unknown", ok: false},
+		{key: "disabled-commands", value: "config", ok: false},
+		{key: "disabled-commands", value: "Lookup,arena", ok: true, out: "arena, lookup"},
+		{key: "unknown", value: "value", ok: false},
+	}
+	for i, tc := range testCases {
+		err := g.Set(tc.key, tc.value)
+		if (err == nil) != tc.ok {
+			t.Errorf("Test case #%d: unexpected error setting %s=%s: %v", i, tc.key, tc.value, err)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if out, _ := g.Get(tc.key); out != tc.out {
+			t.Errorf("Test case #%d: unexpected value for %s: '%v', expected '%v'", i, tc.key, out, tc.out)
+		}
+	}
+
+	if !g.IsDisabled("lookup") || g.IsDisabled("stats") {
+		t.Errorf("Unexpected disabled commands: %v", g.DisabledCommands)
+	}
+	if !g.IsBotChannel(&discordgo.Channel{ID: "777"}) || !g.IsBotChannel(&discordgo.Channel{Name: "bots"}) {
+		t.Errorf("Unexpected bot channels: %v", g.BotChannels)
+	}
+	if g.IsBotChannel(&discordgo.Channel{ID: "1", Name: "general"}) {
+		t.Errorf("Unexpected bot channel #general")
+	}
+	if !g.IsRegistryChannel(&discordgo.Channel{ID: "12345"}) {
+		t.Errorf("Unexpected registry channel: %v", g.RegistryChannel)
+	}
+
+	if g.Color() != 0 {
+		t.Errorf("Unexpected embed color: %#06x", g.Color())
+	}
+
+	g.Reset("prefix")
+	if d := g.WithDefaults(); d.Prefix != *cmdPrefix {
+		t.Errorf("Unexpected prefix after reset: %v", d.Prefix)
+	}
+	g.Reset("embed-color")
+	if g.Color() != conf().EmbedColor {
+		t.Errorf("Unexpected embed color after reset: %#06x", g.Color())
+	}
+}
+
+func TestGuildSettingsStore(t *testing.T) {
+	s := newTestStore(t)
+	c := NewCache(s, "guild", "Guild")
+	if d := c.Settings().WithDefaults(); d.RegistryChannel != defaultRegistryChannel || d.Color() != conf().EmbedColor {
+		t.Errorf("Unexpected default settings: %#v", d)
+	}
+	settings := c.Settings()
+	settings.Set("prefix", "!")
+	if err := c.SetSettings(settings); err != nil {
+		t.Fatalf("Unable to save settings: %v", err)
+	}
+	if p := NewCache(s, "guild", "Guild").Settings().Prefix; p != "!" {
+		t.Errorf("Unexpected saved prefix: %v", p)
+	}
+}
+
+func TestGuildSettingsDisable(t *testing.T) {
+	var g GuildSettings
+	g.Set("disabled-commands", "arena")
+	shared := g
+	g.Disable("lookup", "Too slow here")
+	g.Disable("mods", "")
+	if msg, ok := g.DisabledMessage("lookup"); !ok || msg != "Too slow here" {
+		t.Errorf("Unexpected lookup message: %q %v", msg, ok)
+	}
+	if out, _ := g.Get("disabled-commands"); out != `arena, lookup ("Too slow here"), mods` {
+		t.Errorf("Unexpected disabled commands: %v", out)
+	}
+	if shared.IsDisabled("lookup") || len(shared.DisabledMessages) != 0 {
+		t.Errorf("Unexpected change to a copy of the settings: %#v", shared)
+	}
+
+	g.Enable("lookup")
+	if _, ok := g.DisabledMessage("lookup"); ok || len(g.DisabledMessages) != 0 {
+		t.Errorf("Unexpected lookup still disabled: %#v", g)
+	}
+	g.Reset("disabled-commands")
+	if g.IsDisabled("arena") || g.IsDisabled("mods") {
+		t.Errorf("Unexpected disabled commands after reset: %v", g.DisabledCommands)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ShardState is the gateway connection state of a shard.
+type ShardState int
+
+// Shard connection states.
+const (
+	ShardConnecting ShardState = iota
+	ShardConnected
+	ShardDisconnected
+	ShardStopped
+)
+
+func (s ShardState) String() string {
+	switch s {
+	case ShardConnecting:
+		return "connecting"
+	case ShardConnected:
+		return "connected"
+	case ShardDisconnected:
+		return "disconnected"
+	case ShardStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("ShardState(%d)", int(s))
+}
+
+// ShardStatus is a snapshot of a shard connection.
+type ShardStatus struct {
+	ID       int
+	State    ShardState
+	Since    time.Time
+	Guilds   int
+	Restarts int
+}
+
+// Shard is one of the bot gateway connections. Its state is updated
+// from the session events.
+type Shard struct {
+	ID int
+
+	mu       sync.Mutex
+	session  io.Closer
+	gen      int
+	state    ShardState
+	since    time.Time
+	guilds   map[string]bool
+	restarts int
+}
+
+// setState changes the shard state, if it is not stopped.
+func (sh *Shard) setState(state ShardState, now time.Time) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.state == state || sh.state == ShardStopped {
+		return
+	}
+	sh.state, sh.since = state, now
+}
+
+// events returns the event handler of the session generation gen,
+// so late events from a closed session are ignored.
+func (sh *Shard) events(gen int) func(*discordgo.Session, interface{}) {
+	return func(s *discordgo.Session, event interface{}) {
+		sh.mu.Lock()
+		current := sh.gen == gen
+		sh.mu.Unlock()
+		if current {
+			sh.onEvent(event)
+		}
+	}
+}
+
+// onEvent tracks the connection state and the guilds of the shard.
+func (sh *Shard) onEvent(event interface{}) {
+	now := time.Now()
+	switch e := event.(type) {
+	case *discordgo.Connect:
+		sh.setState(ShardConnected, now)
+	case *discordgo.Resumed:
+		sh.setState(ShardConnected, now)
+	case *discordgo.Disconnect:
+		sh.setState(ShardDisconnected, now)
+	case *discordgo.Ready:
+		sh.setState(ShardConnected, now)
+		sh.mu.Lock()
+		sh.guilds = make(map[string]bool)
+		for _, g := range e.Guilds {
+			sh.guilds[g.ID] = true
+		}
+		sh.mu.Unlock()
+	case *discordgo.GuildCreate:
+		sh.mu.Lock()
+		sh.guilds[e.ID] = true
+		sh.mu.Unlock()
+	case *discordgo.GuildDelete:
+		// Unavailable guilds are still ours, just in an outage
+		if e.Unavailable {
+			return
+		}
+		sh.mu.Lock()
+		delete(sh.guilds, e.ID)
+		sh.mu.Unlock()
+	}
+}
+
+// Status returns the current shard status.
+func (sh *Shard) Status() ShardStatus {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return ShardStatus{
+		ID:       sh.ID,
+		State:    sh.state,
+		Since:    sh.since,
+		Guilds:   len(sh.guilds),
+		Restarts: sh.restarts,
+	}
+}
+
+// ShardOpener opens the gateway session of the shard id, out of count
+// shards, adding the events handler to the session.
+type ShardOpener func(id, count int, events func(*discordgo.Session, interface{})) (io.Closer, error)
+
+// ShardManager runs the bot gateway shards, restarting the ones that
+// stay disconnected, or never finish connecting, for too long.
+type ShardManager struct {
+	// RestartAfter is how long a shard can stay disconnected or connecting
+	// before it is restarted. The session reconnects by itself before that.
+	RestartAfter time.Duration
+	// CheckInterval is how often the shards are checked.
+	CheckInterval time.Duration
+
+	open   ShardOpener
+	shards []*Shard
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewShardManager creates a manager for count shards, opened with open.
+func NewShardManager(count int, open ShardOpener) *ShardManager {
+	m := &ShardManager{
+		RestartAfter:  5 * time.Minute,
+		CheckInterval: 30 * time.Second,
+		open:          open,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for id := 0; id < count; id++ {
+		m.shards = append(m.shards, &Shard{ID: id, guilds: make(map[string]bool)})
+	}
+	return m
+}
+
+// Start opens all shards and starts watching them. Shards that fail to
+// open are retried after RestartAfter.
+func (m *ShardManager) Start() {
+	for _, sh := range m.shards {
+		logger.Infof("Launching shard ID %v", sh.ID)
+		m.connect(sh)
+	}
+	go m.watch()
+}
+
+// connect opens the shard session, closing the previous one.
+func (m *ShardManager) connect(sh *Shard) {
+	sh.mu.Lock()
+	old := sh.session
+	sh.session = nil
+	sh.gen++
+	gen := sh.gen
+	sh.state, sh.since = ShardConnecting, time.Now()
+	sh.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	session, err := m.open(sh.ID, len(m.shards), sh.events(gen))
+	if err != nil {
+		logger.Errorf("Error opening shard %d: %v", sh.ID, err)
+		sh.setState(ShardDisconnected, time.Now())
+		return
+	}
+	sh.mu.Lock()
+	sh.session = session
+	sh.mu.Unlock()
+}
+
+// watch restarts the shards until Close is called.
+func (m *ShardManager) watch() {
+	defer close(m.done)
+	t := time.NewTicker(m.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-t.C:
+			m.check(now)
+		}
+	}
+}
+
+// check restarts the shards disconnected, or still connecting,
+// for longer than RestartAfter.
+func (m *ShardManager) check(now time.Time) {
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		state := sh.state
+		failed := (state == ShardDisconnected || state == ShardConnecting) && now.Sub(sh.since) >= m.RestartAfter
+		if failed {
+			sh.restarts++
+		}
+		sh.mu.Unlock()
+		if failed {
+			logger.Errorf("Shard %d %v for more than %v, restarting", sh.ID, state, m.RestartAfter)
+			m.connect(sh)
+		}
+	}
+}
+
+// Status returns the status of all shards.
+func (m *ShardManager) Status() []ShardStatus {
+	status := make([]ShardStatus, len(m.shards))
+	for i, sh := range m.shards {
+		status[i] = sh.Status()
+	}
+	return status
+}
+
+// Close stops watching the shards and closes their sessions.
+func (m *ShardManager) Close() {
+	close(m.stop)
+	<-m.done
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		session := sh.session
+		sh.session = nil
+		sh.state, sh.since = ShardStopped, time.Now()
+		sh.mu.Unlock()
+		if session != nil {
+			if err := session.Close(); err != nil {
+				logger.Errorf("Error closing shard %d: %v", sh.ID, err)
+			}
+		}
+	}
+}
+
+// discordShards returns a ShardOpener that connects to the Discord gateway
+// with the bot token and the event handlers.
+func discordShards(token string, handlers ...interface{}) ShardOpener {
+	return func(id, count int, events func(*discordgo.Session, interface{})) (io.Closer, error) {
+		dg, err := discordgo.New("Bot " + token)
+		if err != nil {
+			return nil, err
+		}
+		dg.ShardCount = count
+		dg.ShardID = id
+		dg.AddHandler(events)
+		for _, h := range handlers {
+			dg.AddHandler(h)
+		}
+		if err := dg.Open(); err != nil {
+			return nil, err
+		}
+		return dg, nil
+	}
+}
+
+// recommendedShards asks the Discord gateway how many shards the bot should use.
+func recommendedShards(token string) (int, error) {
+	dg, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return 0, err
+	}
+	gw, err := dg.GatewayBot()
+	if err != nil {
+		return 0, err
+	}
+	if gw.Shards < 1 {
+		return 1, nil
+	}
+	return gw.Shards, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeShardSession records if the shard session was closed.
+type fakeShardSession struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *fakeShardSession) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func TestShardManager(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		sessions []*fakeShardSession
+		events   = make(map[int]func(*discordgo.Session, interface{}))
+		fail     = true
+	)
+	m := NewShardManager(2, func(id, count int, e func(*discordgo.Session, interface{})) (io.Closer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if count != 2 {
+			t.Errorf("Unexpected shard count: %d", count)
+		}
+		// Shard 1 fails to open the first time
+		if id == 1 && fail {
+			fail = false
+			return nil, errors.New("gateway unavailable")
+		}
+		events[id] = e
+		s := &fakeShardSession{}
+		sessions = append(sessions, s)
+		return s, nil
+	})
+	m.CheckInterval = time.Hour
+	m.Start()
+
+	old := events[0]
+	old(nil, &discordgo.Connect{})
+	old(nil, &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "a"}, {ID: "b"}}})
+	old(nil, &discordgo.GuildCreate{Guild: &discordgo.Guild{ID: "c"}})
+	old(nil, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: "a"}})
+	old(nil, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: "b", Unavailable: true}})
+
+	status := m.Status()
+	if s := status[0]; s.State != ShardConnected || s.Guilds != 2 {
+		t.Errorf("Unexpected shard 0 status: %+v", s)
+	}
+	if s := status[1]; s.State != ShardDisconnected {
+		t.Errorf("Unexpected shard 1 status: %+v", s)
+	}
+
+	// Only shards disconnected for too long are restarted
+	old(nil, &discordgo.Disconnect{})
+	m.check(time.Now())
+	if len(sessions) != 1 {
+		t.Errorf("Unexpected restart before RestartAfter: %d sessions", len(sessions))
+	}
+	m.check(time.Now().Add(m.RestartAfter))
+	status = m.Status()
+	if len(sessions) != 3 || !sessions[0].closed {
+		t.Errorf("Expected both shards restarted and the old session closed: %+v", sessions)
+	}
+	for _, s := range status {
+		if s.State != ShardConnecting || s.Restarts != 1 {
+			t.Errorf("Unexpected status after restart: %+v", s)
+		}
+	}
+
+	// Events from the closed session are ignored
+	old(nil, &discordgo.Connect{})
+	if s := m.Status()[0]; s.State != ShardConnecting {
+		t.Errorf("Unexpected state change from a closed session: %+v", s)
+	}
+
+	// Shards that never finish connecting are restarted too
+	events[0](nil, &discordgo.Connect{})
+	m.check(time.Now().Add(m.RestartAfter))
+	status = m.Status()
+	if len(sessions) != 4 || !sessions[2].closed || sessions[1].closed {
+		t.Errorf("Expected only the connecting shard restarted: %+v", sessions)
+	}
+	if s := status[0]; s.State != ShardConnected || s.Restarts != 1 {
+		t.Errorf("Unexpected shard 0 status: %+v", s)
+	}
+	if s := status[1]; s.State != ShardConnecting || s.Restarts != 2 {
+		t.Errorf("Unexpected shard 1 status: %+v", s)
+	}
+
+	m.Close()
+	for _, s := range m.Status() {
+		if s.State != ShardStopped {
+			t.Errorf("Unexpected status after Close: %+v", s)
+		}
+	}
+	for i, s := range sessions {
+		if !s.closed {
+			t.Errorf("Session %d not closed", i)
+		}
+	}
+}
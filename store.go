@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeFile is the name of the bot database inside the cache directory.
+const storeFile = "ap-5r.db"
+
+var (
+	linksBucket    = []byte("links")
+	guildsBucket   = []byte("guilds")
+	settingsBucket = []byte("settings")
+	usageBucket    = []byte("usage")
+	commandsBucket = []byte("commands")
+)
+
+// usageDayFormat is the key format of the daily usage buckets.
+const usageDayFormat = "2006-01-02"
+
+// ProfileLink associates a Discord user with a game account.
+// A user can link several accounts, each one with an unique Label
+// (main, alt1, ...), and one of them marked as the Default account.
+// MessageID and ChannelID records the message the link came from,
+// so it can be updated or removed when the message changes.
+// Links registered with the /register command have no source message.
+type ProfileLink struct {
+	UserID    string    `json:"userId"`
+	Label     string    `json:"label"`
+	Default   bool      `json:"default,omitempty"`
+	AllyCode  string    `json:"allyCode,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	ChannelID string    `json:"channelId,omitempty"`
+	MessageID string    `json:"messageId,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// GuildRecord holds guild-wide metadata persisted by the bot.
+type GuildRecord struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// Store is the persistent bot database, backed by an embedded bolt file.
+// A nil *Store is valid and behaves as an empty, memory-only store.
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens (or creates) the bot database at the provided directory.
+func OpenStore(dir string) (*Store, error) {
+	db, err := bolt.Open(path.Join(dir, storeFile), 0600, &bolt.Options{
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{linksBucket, guildsBucket, settingsBucket, usageBucket, commandsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database file.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Guilds returns all guild records known by the store.
+func (s *Store) Guilds() (guilds []*GuildRecord, err error) {
+	if s == nil {
+		return nil, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(guildsBucket).ForEach(func(k, v []byte) error {
+			g := &GuildRecord{}
+			if err := json.Unmarshal(v, g); err != nil {
+				return err
+			}
+			guilds = append(guilds, g)
+			return nil
+		})
+	})
+	return guilds, err
+}
+
+// Guild returns the guild record for the provided ID, if any.
+func (s *Store) Guild(guildID string) (g *GuildRecord, ok bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(guildsBucket).Get([]byte(guildID))
+		if v == nil {
+			return nil
+		}
+		g = &GuildRecord{}
+		if err := json.Unmarshal(v, g); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return g, ok
+}
+
+// PutGuild saves the guild record.
+func (s *Store) PutGuild(g *GuildRecord) error {
+	if s == nil {
+		return nil
+	}
+	return s.put(guildsBucket, nil, g.ID, g)
+}
+
+// Settings returns the settings saved for the guild.
+func (s *Store) Settings(guildID string) (settings GuildSettings, err error) {
+	if s == nil {
+		return settings, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(settingsBucket).Get([]byte(guildID))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &settings)
+	})
+	return settings, err
+}
+
+// PutSettings saves the settings for the guild.
+func (s *Store) PutSettings(guildID string, settings GuildSettings) error {
+	if s == nil {
+		return nil
+	}
+	return s.put(settingsBucket, nil, guildID, settings)
+}
+
+// DisabledCommands returns the commands disabled in all servers, indexed by name.
+func (s *Store) DisabledCommands() (cmds map[string]*DisabledCommand, err error) {
+	cmds = make(map[string]*DisabledCommand)
+	if s == nil {
+		return cmds, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+			d := &DisabledCommand{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return err
+			}
+			cmds[string(k)] = d
+			return nil
+		})
+	})
+	return cmds, err
+}
+
+// DisabledCommand returns the command if it was disabled in all servers.
+func (s *Store) DisabledCommand(name string) (d *DisabledCommand, ok bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(commandsBucket).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		d = &DisabledCommand{}
+		if err := json.Unmarshal(v, d); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return d, ok
+}
+
+// PutDisabledCommand disables the command in all servers.
+func (s *Store) PutDisabledCommand(d *DisabledCommand) error {
+	if s == nil {
+		return nil
+	}
+	return s.put(commandsBucket, nil, d.Command, d)
+}
+
+// DeleteDisabledCommand enables the command again in all servers.
+func (s *Store) DeleteDisabledCommand(name string) error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(commandsBucket).Delete([]byte(name))
+	})
+}
+
+// Links returns all profile links saved for the guild, indexed by user ID.
+func (s *Store) Links(guildID string) (links map[string][]*ProfileLink, err error) {
+	links = make(map[string][]*ProfileLink)
+	if s == nil {
+		return links, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(linksBucket).Bucket([]byte(guildID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var accounts []*ProfileLink
+			if err := json.Unmarshal(v, &accounts); err != nil {
+				// Single account records saved by older versions
+				link := &ProfileLink{}
+				if err := json.Unmarshal(v, link); err != nil {
+					return err
+				}
+				link.Label, link.Default = defaultAccountLabel, true
+				accounts = append(accounts, link)
+			}
+			links[string(k)] = accounts
+			return nil
+		})
+	})
+	return links, err
+}
+
+// PutLinks saves all the user links for the guild.
+// The user entry is removed if links is empty.
+func (s *Store) PutLinks(guildID, userID string, links []*ProfileLink) error {
+	if s == nil {
+		return nil
+	}
+	if len(links) > 0 {
+		return s.put(linksBucket, []byte(guildID), userID, links)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(linksBucket).Bucket([]byte(guildID))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(userID))
+	})
+}
+
+// ReplaceLinks replaces all user links of the guild, in a single transaction.
+func (s *Store) ReplaceLinks(guildID string, links map[string][]*ProfileLink) error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(linksBucket)
+		if err := root.DeleteBucket([]byte(guildID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := root.CreateBucket([]byte(guildID))
+		if err != nil {
+			return err
+		}
+		for userID, accounts := range links {
+			if len(accounts) == 0 {
+				continue
+			}
+			v, err := json.Marshal(accounts)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(userID), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearLinks removes all user links from the guild.
+func (s *Store) ClearLinks(guildID string) error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(linksBucket).DeleteBucket([]byte(guildID))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// put serializes v as JSON and saves it under key, creating the
+// nested bucket sub inside root if provided.
+func (s *Store) put(root, sub []byte, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(root)
+		if sub != nil {
+			if bucket, err = bucket.CreateBucketIfNotExists(sub); err != nil {
+				return err
+			}
+		}
+		return bucket.Put([]byte(key), b)
+	})
+}
+
+// PutUsage saves the command usage record, in a bucket for the record day.
+// When a new day starts, the days older than retention are removed.
+func (s *Store) PutUsage(u *UsageRecord, retention time.Duration) error {
+	if s == nil {
+		return nil
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	day := []byte(u.Time.UTC().Format(usageDayFormat))
+	key := []byte(fmt.Sprintf("%020d-%s", u.Time.UnixNano(), u.ID))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(usageBucket)
+		bucket := root.Bucket(day)
+		if bucket == nil {
+			if bucket, err = root.CreateBucket(day); err != nil {
+				return err
+			}
+			oldest := []byte(u.Time.Add(-retention).UTC().Format(usageDayFormat))
+			var expired [][]byte
+			root.ForEach(func(k, v []byte) error {
+				if v == nil && bytes.Compare(k, oldest) < 0 {
+					expired = append(expired, k)
+				}
+				return nil
+			})
+			for _, k := range expired {
+				if err := root.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
+		}
+		return bucket.Put(key, b)
+	})
+}
+
+// Usage returns the command usage records since the provided time, oldest first.
+func (s *Store) Usage(since time.Time) (records []*UsageRecord, err error) {
+	if s == nil {
+		return nil, nil
+	}
+	first := []byte(since.UTC().Format(usageDayFormat))
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(usageBucket).Cursor()
+		for day, v := c.Seek(first); day != nil; day, v = c.Next() {
+			if v != nil {
+				continue
+			}
+			err := tx.Bucket(usageBucket).Bucket(day).ForEach(func(k, v []byte) error {
+				u := &UsageRecord{}
+				if err := json.Unmarshal(v, u); err != nil {
+					return err
+				}
+				if !u.Time.Before(since) {
+					records = append(records, u)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
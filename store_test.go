@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "ap-5r-store")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatalf("Unable to open store: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+func TestStoreLinks(t *testing.T) {
+	s := newTestStore(t)
+
+	c := NewCache(s, "guild1", "Guild One")
+	c.SetAllyCode("user1", "123456789")
+	c.SetLink(&ProfileLink{UserID: "user2", Profile: "ronoaldo", MessageID: "msg2"})
+
+	// A new cache for the same guild must see the saved links
+	c = NewCache(s, "guild1", "Guild One")
+	if allyCode, ok := c.AllyCode("user1"); !ok || allyCode != "123456789" {
+		t.Errorf("Unexpected ally code for user1: %v, %v", allyCode, ok)
+	}
+	link, ok := c.Link("user2")
+	if !ok {
+		t.Fatalf("Missing link for user2")
+	}
+	if link.Profile != "ronoaldo" || link.MessageID != "msg2" {
+		t.Errorf("Unexpected link for user2: %#v", link)
+	}
+
+	// Other guilds must not see the links
+	if _, ok := NewCache(s, "guild2", "Guild Two").Link("user1"); ok {
+		t.Errorf("Unexpected link for user1 on guild2")
+	}
+
+	c.RemoveAllProfiles()
+	if links, _ := s.Links("guild1"); len(links) != 0 {
+		t.Errorf("Unexpected links after removing all profiles: %v", links)
+	}
+}
+
+func TestStoreGuilds(t *testing.T) {
+	s := newTestStore(t)
+	if c := NewCache(s, "guild1", "Guild One"); c.Synced() {
+		t.Errorf("Unexpected synced guild before saving it")
+	}
+	if err := s.PutGuild(&GuildRecord{ID: "guild1", Name: "Guild One"}); err != nil {
+		t.Fatalf("Unable to save guild: %v", err)
+	}
+	guilds, err := s.Guilds()
+	if err != nil {
+		t.Fatalf("Unable to list guilds: %v", err)
+	}
+	if len(guilds) != 1 || guilds[0].Name != "Guild One" {
+		t.Errorf("Unexpected guild list: %v", guilds)
+	}
+}
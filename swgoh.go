@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ronoaldo/swgoh/swgohgg"
+	"github.com/ronoaldo/swgoh/swgohhelp"
 )
 
 // Profile is an entity that saves user data from the website
@@ -55,8 +58,12 @@ func (p *Profile) Ship(ship string) *swgohgg.Ship {
 }
 
 // GetProfile returns the profile for the player from a cached API.
-func GetProfile(user string) (*Profile, error) {
-	resp, err := http.Get(fmt.Sprintf("https://swgoh-api.appspot.com/v1/profile/%s", user))
+func GetProfile(ctx context.Context, user string) (*Profile, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://swgoh-api.appspot.com/v1/profile/%s", user), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -75,3 +82,139 @@ func GetProfile(user string) (*Profile, error) {
 	}
 	return profile, nil
 }
+
+// factionAliases are nicknames players use for the game categories.
+var factionAliases = map[string]string{
+	"bh":         "bounty hunter",
+	"rebel scum": "rebel",
+	"terrorist":  "rebel",
+	"troopers":   "imperial trooper",
+	"fo":         "first order",
+	"gr":         "galactic republic",
+	"republic":   "galactic republic",
+	"cis":        "separatist",
+	"ns":         "nightsister",
+	"ls":         "light side",
+	"ds":         "dark side",
+}
+
+// factionNames returns the names of the visible game categories,
+// plus the light and dark side that are added to all units data.
+func factionNames(categories map[string]swgohhelp.DataUnitCategory) []string {
+	names := []string{"Light Side", "Dark Side"}
+	for _, c := range categories {
+		if c.Visible && c.Name != "" && c.Name != "Placeholder" {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeFaction lowercases and removes the plural from a faction name.
+func normalizeFaction(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	if alias, ok := factionAliases[name]; ok {
+		name = alias
+	}
+	if alias, ok := factionAliases[strings.TrimSuffix(name, "s")]; ok {
+		name = alias
+	}
+	return strings.TrimSuffix(name, "s")
+}
+
+// findFaction returns the faction name that matches the query: the same name
+// ignoring case and plurals, or the shortest name starting with the query.
+func findFaction(names []string, query string) (string, bool) {
+	q := normalizeFaction(query)
+	if q == "" {
+		return "", false
+	}
+	var prefixMatch string
+	for _, name := range names {
+		n := normalizeFaction(name)
+		if n == q {
+			return name, true
+		}
+		if strings.HasPrefix(n, q) && (prefixMatch == "" || len(name) < len(prefixMatch)) {
+			prefixMatch = name
+		}
+	}
+	return prefixMatch, prefixMatch != ""
+}
+
+// factionUnits returns all the units in the faction, from the player roster when
+// unlocked, or from the game data with zero rarity otherwise. Unlocked units are
+// sorted first, by rarity, gear and relic, and locked units come last, by name.
+func factionUnits(roster swgohhelp.Roster, data map[string]swgohhelp.DataUnit, faction string, ships bool) []swgohhelp.Unit {
+	combatType := swgohhelp.CombatTypeChar
+	if ships {
+		combatType = swgohhelp.CombatTypeShip
+	}
+	owned := make(map[string]swgohhelp.Unit)
+	for _, u := range roster {
+		owned[u.DefID] = u
+	}
+	var units []swgohhelp.Unit
+	for id, unitData := range data {
+		if swgohhelp.CombatType(unitData.CombatType) != combatType || !hasCategory(unitData.Categories, faction) {
+			continue
+		}
+		u, ok := owned[id]
+		if !ok {
+			d := unitData
+			u = swgohhelp.Unit{DefID: id, Name: unitData.Name, CombatType: combatType, Data: &d}
+		}
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		switch {
+		case a.Rarity != b.Rarity:
+			return a.Rarity > b.Rarity
+		case a.Gear != b.Gear:
+			return a.Gear > b.Gear
+		case a.Relic.Tier != b.Relic.Tier:
+			return a.Relic.Tier > b.Relic.Tier
+		}
+		return a.Name < b.Name
+	})
+	return units
+}
+
+// hasCategory returns true if the category is in the list.
+func hasCategory(categories []string, category string) bool {
+	for _, c := range categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// arenaSquad returns the player arena rank and squad units from the roster,
+// with the role of each unit in the squad. Squad units missing from the roster
+// are returned with their unit ID, like DARTHVADER, as the name.
+func arenaSquad(player *swgohhelp.Player, fleet bool) (rank int, units []swgohhelp.Unit, roles []swgohhelp.SquadUnitType) {
+	arena := player.Arena.Char
+	if fleet {
+		arena = player.Arena.Ship
+	}
+	for _, squadUnit := range arena.Squad {
+		defID := strings.Split(squadUnit.UnitID, ":")[0]
+		var unit *swgohhelp.Unit
+		for i := range player.Roster {
+			u := &player.Roster[i]
+			if u.ID == squadUnit.ID || u.DefID == defID {
+				unit = u
+				break
+			}
+		}
+		if unit == nil {
+			unit = &swgohhelp.Unit{DefID: defID, Name: defID}
+		}
+		units = append(units, *unit)
+		roles = append(roles, squadUnit.Type)
+	}
+	return arena.Rank, units, roles
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ronoaldo/swgoh/swgohhelp"
+)
+
+func TestFindFaction(t *testing.T) {
+	names := []string{"Bounty Hunters", "Dark Side", "Empire", "First Order", "Imperial Trooper", "Light Side", "Rebel", "Rebel Fighter"}
+	testCases := []struct {
+		query    string
+		expected string
+		ok       bool
+	}{
+		{query: "empire", expected: "Empire", ok: true},
+		{query: "Rebels", expected: "Rebel", ok: true},
+		{query: "rebel scum", expected: "Rebel", ok: true},
+		{query: "Terrorists", expected: "Rebel", ok: true},
+		{query: "bh", expected: "Bounty Hunters", ok: true},
+		{query: "bounty hunter", expected: "Bounty Hunters", ok: true},
+		{query: "imperial troopers", expected: "Imperial Trooper", ok: true},
+		{query: "first", expected: "First Order", ok: true},
+		{query: "ds", expected: "Dark Side", ok: true},
+		{query: "jedi", ok: false},
+	}
+	for i, tc := range testCases {
+		faction, ok := findFaction(names, tc.query)
+		if ok != tc.ok || faction != tc.expected {
+			t.Errorf("Test case #%d: unexpected faction for %q: %q (ok=%v), expected %q (ok=%v)",
+				i, tc.query, faction, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestFactionUnits(t *testing.T) {
+	data := map[string]swgohhelp.DataUnit{
+		"VADER":        {Name: "Darth Vader", CombatType: 1, Categories: []string{"Empire", "Sith"}},
+		"TIEFIGHTER":   {Name: "TIE Fighter Pilot", CombatType: 1, Categories: []string{"Empire", "Imperial Trooper"}},
+		"VEERS":        {Name: "General Veers", CombatType: 1, Categories: []string{"Empire", "Imperial Trooper"}},
+		"TIEADVANCED":  {Name: "TIE Advanced x1", CombatType: 2, Categories: []string{"Empire"}},
+		"HANSOLO":      {Name: "Han Solo", CombatType: 1, Categories: []string{"Rebel"}},
+		"STORMTROOPER": {Name: "Stormtrooper", CombatType: 1, Categories: []string{"Empire", "Imperial Trooper"}},
+	}
+	roster := swgohhelp.Roster{
+		{DefID: "VEERS", Name: "General Veers", Rarity: 7, Gear: 12},
+		{DefID: "TIEFIGHTER", Name: "TIE Fighter Pilot", Rarity: 7, Gear: 13},
+		{DefID: "HANSOLO", Name: "Han Solo", Rarity: 7, Gear: 13},
+	}
+	units := factionUnits(roster, data, "Empire", false)
+	var names []string
+	for _, u := range units {
+		names = append(names, u.Name)
+	}
+	expected := []string{"TIE Fighter Pilot", "General Veers", "Darth Vader", "Stormtrooper"}
+	if len(names) != len(expected) {
+		t.Fatalf("Unexpected faction units: %v, expected %v", names, expected)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Unexpected unit #%d: %v, expected %v", i, names[i], expected[i])
+		}
+	}
+	if units[2].Rarity != 0 {
+		t.Errorf("Locked unit should have zero rarity: %v", units[2].Rarity)
+	}
+	if ships := factionUnits(roster, data, "Empire", true); len(ships) != 1 || ships[0].Name != "TIE Advanced x1" {
+		t.Errorf("Unexpected faction ships: %v", ships)
+	}
+}
+
+func TestArenaSquad(t *testing.T) {
+	player := &swgohhelp.Player{
+		Roster: swgohhelp.Roster{
+			{ID: "a", DefID: "DARTHTRAYA", Name: "Darth Traya", Rarity: 7},
+			{ID: "b", DefID: "DARTHSION", Name: "Darth Sion", Rarity: 7},
+			{ID: "c", DefID: "CAPITALCHIMAERA", Name: "Chimaera", Rarity: 7},
+		},
+		Arena: swgohhelp.Arena{
+			Char: swgohhelp.ArenaRanking{Rank: 12, Squad: []swgohhelp.SquadUnit{
+				{ID: "a", UnitID: "DARTHTRAYA", Type: swgohhelp.SquadUnitLeader},
+				{ID: "b", UnitID: "DARTHSION", Type: swgohhelp.SquadUnitNormal},
+			}},
+			Ship: swgohhelp.ArenaRanking{Rank: 3, Squad: []swgohhelp.SquadUnit{
+				{ID: "c", UnitID: "CAPITALCHIMAERA", Type: swgohhelp.SquadUnitCapitalShip},
+				{ID: "x", UnitID: "TIEADVANCED", Type: swgohhelp.SquadUnitReinforcement},
+			}},
+		},
+	}
+	rank, units, roles := arenaSquad(player, false)
+	if rank != 12 || len(units) != 2 || units[0].Name != "Darth Traya" || roles[0] != swgohhelp.SquadUnitLeader {
+		t.Errorf("Unexpected squad arena: rank=%d units=%v roles=%v", rank, units, roles)
+	}
+	rank, units, roles = arenaSquad(player, true)
+	if rank != 3 || len(units) != 2 || units[0].Name != "Chimaera" || units[1].Rarity != 0 || roles[1] != swgohhelp.SquadUnitReinforcement {
+		t.Errorf("Unexpected fleet arena: rank=%d units=%v roles=%v", rank, units, roles)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageRecord is a command handled by the bot, saved for the usage reports.
+// Only the flags known by the command are saved, never the message content.
+type UsageRecord struct {
+	ID        string        `json:"id"`
+	Time      time.Time     `json:"time"`
+	Command   string        `json:"command"`
+	GuildID   string        `json:"guildId"`
+	GuildName string        `json:"guildName"`
+	Outcome   string        `json:"outcome"`
+	Latency   time.Duration `json:"latency"`
+	Flags     []string      `json:"flags,omitempty"`
+}
+
+// Failed returns true if the command failed or timed out.
+func (u *UsageRecord) Failed() bool {
+	return u.Outcome == "error" || u.Outcome == "timeout"
+}
+
+// withUsage records the command usage in the store.
+func withUsage(next CmdHandler) CmdHandler {
+	return CmdFunc(func(r CmdRequest) error {
+		start := time.Now()
+		err := next.HandleCommand(r)
+		u := &UsageRecord{
+			ID:        r.id,
+			Time:      start,
+			Command:   r.cmd.Name,
+			GuildID:   r.guild.ID,
+			GuildName: r.guild.Name,
+			Outcome:   commandOutcome(err),
+			Latency:   time.Since(start),
+			Flags:     knownFlags(r.cmd, r.args.Flags),
+		}
+		if err := store.PutUsage(u, conf().UsageRetention); err != nil {
+			r.l.Errorf("Unable to save command usage: %v", err)
+		}
+		return err
+	})
+}
+
+// knownFlags returns the flags that are documented in the command.
+func knownFlags(cmd *Command, flags []string) (known []string) {
+	for _, f := range flags {
+		for _, k := range cmd.Flags {
+			if strings.EqualFold(f, k) {
+				known = append(known, k)
+				break
+			}
+		}
+	}
+	return known
+}
+
+// usageStats is the usage summary of a command, server or day.
+type usageStats struct {
+	Name    string
+	Count   int
+	Errors  int
+	Latency time.Duration
+}
+
+func (s *usageStats) add(u *UsageRecord) {
+	s.Count++
+	s.Latency += u.Latency
+	if u.Failed() {
+		s.Errors++
+	}
+}
+
+func (s *usageStats) String() string {
+	avg := time.Duration(0)
+	if s.Count > 0 {
+		avg = s.Latency / time.Duration(s.Count)
+	}
+	return fmt.Sprintf("%s: %d (%s errors, avg %v)", s.Name, s.Count, errorRate(s.Errors, s.Count), avg.Round(10*time.Millisecond))
+}
+
+func errorRate(errors, count int) string {
+	if count == 0 {
+		return "0%"
+	}
+	return strconv.FormatFloat(100*float64(errors)/float64(count), 'f', 1, 64) + "%"
+}
+
+// usageReport summarizes the usage records by command, server and day,
+// listing at most top commands and servers.
+func usageReport(records []*UsageRecord, top int) string {
+	total := &usageStats{Name: "Total"}
+	byCommand := make(map[string]*usageStats)
+	byGuild := make(map[string]*usageStats)
+	byDay := make(map[string]*usageStats)
+	for _, u := range records {
+		total.add(u)
+		usageGroup(byCommand, u.Command, u.Command).add(u)
+		usageGroup(byGuild, u.GuildID, u.GuildName).add(u)
+		day := u.Time.UTC().Format(usageDayFormat)
+		usageGroup(byDay, day, day).add(u)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v\n", total)
+	fmt.Fprintf(&b, "\n**By command**\n")
+	writeUsageStats(&b, byCommand, top, true)
+	fmt.Fprintf(&b, "\n**By server**\n")
+	writeUsageStats(&b, byGuild, top, true)
+	fmt.Fprintf(&b, "\n**By day**\n")
+	writeUsageStats(&b, byDay, 0, false)
+	return b.String()
+}
+
+func usageGroup(groups map[string]*usageStats, key, name string) *usageStats {
+	s, ok := groups[key]
+	if !ok {
+		s = &usageStats{Name: name}
+		groups[key] = s
+	}
+	return s
+}
+
+// writeUsageStats writes the stats sorted by count or by name, up to top lines if top > 0.
+func writeUsageStats(b *bytes.Buffer, groups map[string]*usageStats, top int, byCount bool) {
+	list := make([]*usageStats, 0, len(groups))
+	for _, s := range groups {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if byCount && list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	for i, s := range list {
+		if top > 0 && i >= top {
+			fmt.Fprintf(b, "... and %d more\n", len(list)-top)
+			break
+		}
+		fmt.Fprintf(b, "%v\n", s)
+	}
+}
+
+// cmdBotStats reports the number of servers and the command usage
+// of the last days.
+func cmdBotStats(r CmdRequest) (err error) {
+	days := 7
+	if n, err := strconv.Atoi(strings.TrimSpace(r.args.Name)); err == nil && n > 0 {
+		days = n
+	}
+	guilds := 0
+	if shardManager != nil {
+		for _, s := range shardManager.Status() {
+			guilds += s.Guilds
+		}
+	} else {
+		guilds = listMyGuilds(r.s)
+	}
+	records, err := store.Usage(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+	report := fmt.Sprintf("Running on **%d** guilds. Usage in the last %d days:\n%s", guilds, days, usageReport(records, 10))
+	for _, msg := range splitMessage(report, maxReportSize) {
+		if _, err = r.s.ChannelMessageSend(r.m.ChannelID, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage splits the text in lines, into messages of up to max bytes.
+// Longer lines are cut.
+func splitMessage(text string, max int) (messages []string) {
+	var b bytes.Buffer
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if len(line) > max {
+			line = line[:max]
+		}
+		if b.Len() > 0 && b.Len()+len(line)+1 > max {
+			messages = append(messages, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		messages = append(messages, b.String())
+	}
+	return messages
+}
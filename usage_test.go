@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreUsage(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	retention := 7 * 24 * time.Hour
+	for _, u := range []*UsageRecord{
+		{ID: "old", Time: now.AddDate(0, 0, -10), Command: "stats"},
+		{ID: "a", Time: now.AddDate(0, 0, -2), Command: "stats"},
+		{ID: "b", Time: now, Command: "arena"},
+	} {
+		if err := s.PutUsage(u, retention); err != nil {
+			t.Fatalf("Unable to save usage: %v", err)
+		}
+	}
+	records, err := s.Usage(now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("Unable to load usage: %v", err)
+	}
+	var ids []string
+	for _, u := range records {
+		ids = append(ids, u.ID)
+	}
+	// The old record day was removed when the newer days were created
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("Unexpected usage records: %v", ids)
+	}
+	if records, _ = s.Usage(now.Add(-time.Hour)); len(records) != 1 {
+		t.Errorf("Unexpected usage records in the last hour: %v", records)
+	}
+}
+
+func TestCmdBotStats(t *testing.T) {
+	old := store
+	store = newTestStore(t)
+	defer func() { store = old }()
+	setTestConfig(t, func(c *Config) { c.Owners = []string{"100"} })
+
+	h := newTestHarness(t)
+	h.send("usage-user", "/help")
+	h.send("usage-user", "/stats +ships")
+	if err := h.send("100", "/bot-stats"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{"Usage in the last 7 days", "help: 1 (0.0% errors", "stats: 1", "Test Guild: 2"} {
+		h.expectReply(expected)
+	}
+
+	records, _ := store.Usage(time.Now().Add(-time.Hour))
+	for _, u := range records {
+		if u.Command == "stats" && (len(u.Flags) != 1 || u.Flags[0] != "+ships" || u.Outcome != "no_profile") {
+			t.Errorf("Unexpected stats usage record: %+v", u)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	messages := splitMessage("aaaa\nbbbb\ncccc\n", 9)
+	if strings.Join(messages, "|") != "aaaa\nbbbb|cccc" {
+		t.Errorf("Unexpected messages: %q", messages)
+	}
+}